@@ -5,7 +5,9 @@ import (
 	"os"
 
 	"github.com/wantedly/rigger/pkg/apis"
+	"github.com/wantedly/rigger/pkg/clientset"
 	"github.com/wantedly/rigger/pkg/controller"
+	"github.com/wantedly/rigger/pkg/util"
 	"github.com/wantedly/rigger/pkg/webhook"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
@@ -15,8 +17,10 @@ import (
 )
 
 func main() {
-	var metricsAddr string
+	var metricsAddr, syncResources string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&syncResources, "sync-resources", "", "The namespaced resources which plans may sync other than secrets and configmaps, "+
+		"separated by commas, each in the form of resource.version.group such as externalsecrets.v1beta1.external-secrets.io.")
 	flag.Parse()
 	logf.SetLogger(logf.ZapLogger(false))
 	log := logf.Log.WithName("entrypoint")
@@ -29,6 +33,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Setup the clients with which the controllers write the synced objects
+	if err := clientset.SetConfig(cfg); err != nil {
+		log.Error(err, "unable to set up clientset")
+		os.Exit(1)
+	}
+
 	// Create a new Cmd to provide shared dependencies and start components
 	log.Info("setting up manager")
 	mgr, err := manager.New(cfg, manager.Options{MetricsBindAddress: metricsAddr})
@@ -46,6 +56,22 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Setup the resources to sync, which the controllers watch
+	log.Info("setting up sync resources")
+	resources, err := util.ParseSyncResources(syncResources)
+	if err != nil {
+		log.Error(err, "unable to parse sync resources")
+		os.Exit(1)
+	}
+	for _, gvr := range resources {
+		gvk, err := mgr.GetRESTMapper().KindFor(gvr)
+		if err != nil {
+			log.Error(err, "unable to find the kind of sync resource", "resource", gvr.String())
+			os.Exit(1)
+		}
+		clientset.RegisterResource(gvk, gvr)
+	}
+
 	// Setup all Controllers
 	log.Info("Setting up controller")
 	if err := controller.AddToManager(mgr); err != nil {
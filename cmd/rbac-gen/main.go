@@ -0,0 +1,56 @@
+// rbac-gen prints the ClusterRole which allows the manager to sync the resources given by --sync-resources,
+// which must be the same as the flag of the manager.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/wantedly/rigger/pkg/util"
+
+	"github.com/ghodss/yaml"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func main() {
+	var name, syncResources string
+	flag.StringVar(&name, "name", "manager-sync-role", "The name of the ClusterRole.")
+	flag.StringVar(&syncResources, "sync-resources", "", "The resources to sync other than secrets and configmaps, see the flag of the manager.")
+	flag.Parse()
+
+	resources, err := util.ParseSyncResources(syncResources)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to parse sync resources: %v\n", err)
+		os.Exit(1)
+	}
+	out, err := yaml.Marshal(newSyncResourcesRole(name, resources))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to marshal ClusterRole: %v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(out)
+}
+
+// newSyncResourcesRole returns the ClusterRole named name which allows the manager to sync the resources.
+func newSyncResourcesRole(name string, resources []schema.GroupVersionResource) *rbacv1.ClusterRole {
+	role := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Rules:      []rbacv1.PolicyRule{},
+	}
+	for _, gvr := range resources {
+		role.Rules = append(role.Rules, rbacv1.PolicyRule{
+			APIGroups: []string{gvr.Group},
+			Resources: []string{gvr.Resource},
+			Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+		}, rbacv1.PolicyRule{
+			APIGroups: []string{gvr.Group},
+			Resources: []string{gvr.Resource + "/status"},
+			Verbs:     []string{"get", "update", "patch"},
+		})
+	}
+	return role
+}
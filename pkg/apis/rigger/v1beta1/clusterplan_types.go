@@ -0,0 +1,50 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterPlan is the Schema for the clusterplans API.
+// Unlike Plan, ClusterPlan syncs secrets across all namespaces.
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Mode",type="string",JSONPath=".spec.mode"
+// +kubebuilder:printcolumn:name="Dest",type="string",JSONPath=".spec.syncDestNamespace"
+// +kubebuilder:printcolumn:name="Synced",type="integer",JSONPath=".status.syncedSecrets"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type ClusterPlan struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PlanSpec   `json:"spec,omitempty"`
+	Status PlanStatus `json:"status,omitempty"`
+}
+
+// GetSpec returns the spec of the ClusterPlan.
+func (p *ClusterPlan) GetSpec() *PlanSpec {
+	return &p.Spec
+}
+
+// GetStatus returns the status of the ClusterPlan.
+func (p *ClusterPlan) GetStatus() *PlanStatus {
+	return &p.Status
+}
+
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterPlanList contains a list of ClusterPlan
+type ClusterPlanList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterPlan `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterPlan{}, &ClusterPlanList{})
+}
@@ -1,7 +1,10 @@
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -12,14 +15,192 @@ type PlanSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
+	// How to sync secrets. Defaults to Collect.
+	// +kubebuilder:validation:Enum=Collect,Distribute,Merge
+	Mode PlanMode `json:"mode,omitempty"`
+
+	// API version of the objects to sync such as "external-secrets.io/v1beta1". Defaults to "v1".
+	APIVersion string `json:"apiVersion,omitempty"`
+
+	// Kind of the objects to sync. Defaults to Secret.
+	// Kinds other than Secret and ConfigMap must be configured by the --sync-resources flag of the manager.
+	Kind string `json:"kind,omitempty"`
+
+	// The object to distribute in Distribute mode.
+	Source *corev1.SecretReference `json:"source,omitempty"`
+
 	// Secret name of the target to sync.
+	// Shorthand for a SyncTargets entry with only the name.
 	SyncTargetSecretName string `json:"syncTargetSecretName,omitempty"`
 
+	// Secrets of the targets to sync.
+	SyncTargets []SyncTarget `json:"syncTargets,omitempty"`
+
+	// Go template of the names of the synced secrets in Collect mode, given the Namespace, Name, Labels
+	// and Annotations of the source secret. Defaults to "{{.Namespace}}.{{.Name}}".
+	DestinationNameTemplate string `json:"destinationNameTemplate,omitempty"`
+
 	// The namespace to register synced secrets.
 	SyncDestNamespace string `json:"syncDestNamespace,omitempty"`
 
-	// Do not sync from specified Namespaces.
+	// Name of the secret into which the target secrets are merged in Merge mode. Defaults to the name of the plan.
+	MergedSecretName string `json:"mergedSecretName,omitempty"`
+
+	// Renders the merged secret from the target secrets in Merge mode, instead of prefixing their keys.
+	Template *SecretTemplate `json:"template,omitempty"`
+
+	// Do not sync from specified Namespaces, or to them in Distribute mode.
+	// Each entry is a Namespace name, a glob such as "kube-*" or a regular expression such as "^istio-.*$".
 	IgnoreNamespaces []string `json:"ignoreNamespaces,omitempty"`
+
+	// Sync only from specified Namespaces, or to them in Distribute mode. All Namespaces if empty.
+	// Entries take the same forms as IgnoreNamespaces.
+	IncludeNamespaces []string `json:"includeNamespaces,omitempty"`
+
+	// Sync only from Namespaces matching the label selector, or to them in Distribute mode.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// The labels of the source secret to copy to the synced secrets. No labels are copied if unset.
+	PropagateLabels *MetadataRule `json:"propagateLabels,omitempty"`
+
+	// The annotations of the source secret to copy to the synced secrets. No annotations are copied if unset.
+	PropagateAnnotations *MetadataRule `json:"propagateAnnotations,omitempty"`
+
+	// The data keys of the source secret to copy to the synced secrets. All keys are copied if unset.
+	Keys *KeyRule `json:"keys,omitempty"`
+
+	// Renames of the copied data keys, applied after Keys.
+	KeyMappings []KeyMapping `json:"keyMappings,omitempty"`
+
+	// The fields of the source object to copy to the synced objects, for kinds other than Secret and ConfigMap.
+	// All fields but metadata are copied if unset.
+	Fields *FieldRule `json:"fields,omitempty"`
+}
+
+// SecretTemplate renders a secret from the target secrets of a Plan in Merge mode.
+type SecretTemplate struct {
+	// Type of the rendered secret such as "kubernetes.io/dockerconfigjson". Defaults to Opaque.
+	Type corev1.SecretType `json:"type,omitempty"`
+
+	// Go templates of the data of the rendered secret by key. They are given .Sources, the target secrets sorted
+	// by their namespaces and names, each of which has Namespace, Name, Labels, Annotations and Data as strings.
+	// The functions b64enc, b64dec and json are available.
+	Data map[string]string `json:"data"`
+}
+
+// KeyRule selects the data keys of a source secret to copy to the synced secrets.
+type KeyRule struct {
+	// Copy only the keys matching any of the entries. All keys if empty.
+	// Each entry is a key or a glob such as "db-*".
+	Include []string `json:"include,omitempty"`
+
+	// Do not copy the keys matching any of the entries. Entries take the same forms as Include.
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// FieldRule selects the fields of a source object to copy to the synced objects.
+// Each entry is a path of fields joined by dots such as "spec.data". Metadata, apiVersion and kind are never copied.
+type FieldRule struct {
+	// Copy only the fields of the entries. All fields if empty.
+	Include []string `json:"include,omitempty"`
+
+	// Do not copy the fields of the entries, applied after Include.
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// KeyMapping renames a data key of the synced secrets.
+type KeyMapping struct {
+	// The key of the source secret.
+	From string `json:"from"`
+
+	// The key of the synced secrets. It takes precedence over a copied key of the same name.
+	To string `json:"to"`
+}
+
+// MetadataRule selects the labels or annotations of a source secret to copy to the synced secrets.
+// The labels and annotations of rigger itself always take precedence.
+type MetadataRule struct {
+	// Copy only the keys matching any of the entries. All keys if empty.
+	// Each entry is a key or a glob such as "app.kubernetes.io/*".
+	Include []string `json:"include,omitempty"`
+
+	// Do not copy the keys matching any of the entries. Entries take the same forms as Include.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Prefix prepended to the copied keys.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Static entries added to every synced secret.
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// PlanMode is the direction in which a Plan syncs secrets.
+type PlanMode string
+
+const (
+	// PlanModeCollect syncs the target secrets of every Namespace into SyncDestNamespace.
+	PlanModeCollect PlanMode = "Collect"
+	// PlanModeDistribute syncs the Source secret into every Namespace.
+	PlanModeDistribute PlanMode = "Distribute"
+	// PlanModeMerge merges the target secrets of every Namespace into the single secret MergedSecretName
+	// of SyncDestNamespace, prefixing their keys with their Namespaces such as "<namespace>.<key>".
+	PlanModeMerge PlanMode = "Merge"
+)
+
+// DefaultSyncAPIVersion and DefaultSyncKind are the defaults of APIVersion and Kind.
+const (
+	DefaultSyncAPIVersion = "v1"
+	DefaultSyncKind       = "Secret"
+)
+
+// DefaultIgnoreNamespacesAnnotation set to "false" on a Plan or ClusterPlan keeps the defaulting webhook
+// from adding DefaultIgnoreNamespaces to IgnoreNamespaces.
+const DefaultIgnoreNamespacesAnnotation = "rigger.k8s.wantedly.com/default-ignore-namespaces"
+
+// DefaultIgnoreNamespaces are the default IgnoreNamespaces of a created plan, set by the defaulting webhook.
+var DefaultIgnoreNamespaces = []string{"kube-system", "kube-public"}
+
+// GetMode returns Mode, or PlanModeCollect if Mode is empty.
+func (s *PlanSpec) GetMode() PlanMode {
+	if s.Mode == "" {
+		return PlanModeCollect
+	}
+	return s.Mode
+}
+
+// GetGroupVersionKind returns the GroupVersionKind of the objects to sync, defaulting APIVersion and Kind.
+func (s *PlanSpec) GetGroupVersionKind() schema.GroupVersionKind {
+	return syncGroupVersionKind(s.APIVersion, s.Kind)
+}
+
+func syncGroupVersionKind(apiVersion, kind string) schema.GroupVersionKind {
+	if apiVersion == "" {
+		apiVersion = DefaultSyncAPIVersion
+	}
+	if kind == "" {
+		kind = DefaultSyncKind
+	}
+	return schema.FromAPIVersionAndKind(apiVersion, kind)
+}
+
+// SyncTarget selects secrets to sync. At least one of Name and Selector is required.
+type SyncTarget struct {
+	// Secret name to sync.
+	Name string `json:"name,omitempty"`
+
+	// Sync secrets matching the label selector.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Prefix prepended to the names of the synced secrets.
+	DestNamePrefix string `json:"destNamePrefix,omitempty"`
+}
+
+// GetSyncTargets returns SyncTargets together with the target of SyncTargetSecretName.
+func (s *PlanSpec) GetSyncTargets() []SyncTarget {
+	if s.SyncTargetSecretName == "" {
+		return s.SyncTargets
+	}
+	return append([]SyncTarget{{Name: s.SyncTargetSecretName}}, s.SyncTargets...)
 }
 
 // PlanStatus defines the observed state of Plan
@@ -27,16 +208,135 @@ type PlanStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	LastSyncTargetSecretName string   `json:"lastSyncTargetSecretName,omitempty"`
-	LastSyncDestNamespace    string   `json:"lastSyncDestNamespace,omitempty"`
-	LastIgnoreNamespaces     []string `json:"lastIgnoreNamespaces,omitempty"`
+	LastMode                 PlanMode     `json:"lastMode,omitempty"`
+	LastAPIVersion           string       `json:"lastAPIVersion,omitempty"`
+	LastKind                 string       `json:"lastKind,omitempty"`
+	LastSyncTargetSecretName string       `json:"lastSyncTargetSecretName,omitempty"`
+	LastSyncTargets          []SyncTarget `json:"lastSyncTargets,omitempty"`
+	LastSyncDestNamespace    string       `json:"lastSyncDestNamespace,omitempty"`
+	LastIgnoreNamespaces     []string     `json:"lastIgnoreNamespaces,omitempty"`
+	LastIncludeNamespaces    []string     `json:"lastIncludeNamespaces,omitempty"`
+
+	LastNamespaceSelector *metav1.LabelSelector `json:"lastNamespaceSelector,omitempty"`
+
+	LastSource *corev1.SecretReference `json:"lastSource,omitempty"`
+
+	LastDestinationNameTemplate string `json:"lastDestinationNameTemplate,omitempty"`
+
+	// The generation of the spec which the status reflects.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// The last time the secrets were synced.
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// The number of secrets synced by the Plan.
+	SyncedSecrets int32 `json:"syncedSecrets,omitempty"`
+
+	// The latest available observations of the state.
+	Conditions []PlanCondition `json:"conditions,omitempty"`
+
+	// The namespaces which failed to sync in the last sync, at most MaxSyncFailures.
+	Failures []SyncFailure `json:"failures,omitempty"`
+}
+
+// PlanConditionType is a type of PlanCondition.
+type PlanConditionType string
+
+const (
+	// PlanReady means the Plan has synced the secrets as its spec says.
+	PlanReady PlanConditionType = "Ready"
+	// PlanSynced means the last sync succeeded for every source.
+	PlanSynced PlanConditionType = "Synced"
+	// PlanDegraded means the last sync failed for some sources.
+	PlanDegraded PlanConditionType = "Degraded"
+	// PlanLoopDetected means the spec would sync the secrets synced by rigger, which are skipped to avoid recursion.
+	PlanLoopDetected PlanConditionType = "LoopDetected"
+	// PlanRendered means the template of the Plan in Merge mode rendered the merged secret last time.
+	PlanRendered PlanConditionType = "Rendered"
+)
+
+// PlanCondition describes the state of a Plan at a certain point.
+type PlanCondition struct {
+	// Type of the condition.
+	Type PlanConditionType `json:"type"`
+
+	// Status of the condition, one of True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+
+	// The last time the condition transitioned from one status to another.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// The reason for the condition's last transition.
+	Reason string `json:"reason,omitempty"`
+
+	// A human readable message indicating details about the transition.
+	Message string `json:"message,omitempty"`
+}
+
+// MaxSyncFailures is the maximum number of Failures recorded in PlanStatus.
+const MaxSyncFailures = 10
+
+// SyncFailure describes a namespace which failed to sync.
+// The namespace is the source in Collect mode, or the destination in Distribute mode.
+type SyncFailure struct {
+	// Namespace which failed to sync.
+	Namespace string `json:"namespace"`
+
+	// The error message.
+	Message string `json:"message"`
+}
+
+// GetCondition returns the condition of the type, or nil if there is no such condition.
+func (s *PlanStatus) GetCondition(t PlanConditionType) *PlanCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == t {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates the condition of the type.
+// LastTransitionTime is updated only if the status changes.
+func (s *PlanStatus) SetCondition(t PlanConditionType, status corev1.ConditionStatus, reason, message string) {
+	c := s.GetCondition(t)
+	if c == nil {
+		s.Conditions = append(s.Conditions, PlanCondition{Type: t})
+		c = &s.Conditions[len(s.Conditions)-1]
+	}
+	if c.Status != status {
+		c.Status = status
+		c.LastTransitionTime = metav1.Now()
+	}
+	c.Reason = reason
+	c.Message = message
+}
+
+// GetLastMode returns LastMode, or PlanModeCollect if LastMode is empty.
+func (s *PlanStatus) GetLastMode() PlanMode {
+	if s.LastMode == "" {
+		return PlanModeCollect
+	}
+	return s.LastMode
+}
+
+// GetLastGroupVersionKind returns the GroupVersionKind of LastAPIVersion and LastKind, defaulted as in PlanSpec.
+func (s *PlanStatus) GetLastGroupVersionKind() schema.GroupVersionKind {
+	return syncGroupVersionKind(s.LastAPIVersion, s.LastKind)
 }
 
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // Plan is the Schema for the plans API
+// A Plan syncs secrets only within its own namespace. Use ClusterPlan to sync secrets across namespaces.
 // +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Mode",type="string",JSONPath=".spec.mode"
+// +kubebuilder:printcolumn:name="Dest",type="string",JSONPath=".spec.syncDestNamespace"
+// +kubebuilder:printcolumn:name="Synced",type="integer",JSONPath=".status.syncedSecrets"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type Plan struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -45,6 +345,16 @@ type Plan struct {
 	Status PlanStatus `json:"status,omitempty"`
 }
 
+// GetSpec returns the spec of the Plan.
+func (p *Plan) GetSpec() *PlanSpec {
+	return &p.Spec
+}
+
+// GetStatus returns the status of the Plan.
+func (p *Plan) GetStatus() *PlanStatus {
+	return &p.Status
+}
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // PlanList contains a list of Plan
@@ -54,6 +364,18 @@ type PlanList struct {
 	Items           []Plan `json:"items"`
 }
 
+// PlanObject is implemented by Plan and ClusterPlan, which share PlanSpec and PlanStatus.
+// The namespace of a ClusterPlan is empty.
+type PlanObject interface {
+	metav1.Object
+	runtime.Object
+	GetSpec() *PlanSpec
+	GetStatus() *PlanStatus
+}
+
+var _ PlanObject = &Plan{}
+var _ PlanObject = &ClusterPlan{}
+
 func init() {
 	SchemeBuilder.Register(&Plan{}, &PlanList{})
 }
@@ -1,41 +1,38 @@
 package clientset
 
 import (
-	"log"
-	"os"
-	"path/filepath"
-
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
-var clientset = func() *kubernetes.Clientset {
-	var config *rest.Config
+// The clients talking to the API server, which SetConfig sets up.
+var (
+	clientset     *kubernetes.Clientset
+	dynamicClient dynamic.Interface
+)
 
-	config, err := rest.InClusterConfig()
+// SetConfig sets up the clients to talk to the API server by cfg. It must be called before the other functions,
+// with the same config as the manager.
+func SetConfig(cfg *rest.Config) error {
+	c, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
-		if err == rest.ErrNotInCluster {
-			kubeconfig := filepath.Join(os.Getenv("HOME"), ".kube", "config")
-			config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-			if err != nil {
-				log.Fatalf("failed to load local kubernetes config: %v", err)
-			}
-		} else {
-			log.Fatalf("failed to load in-cluster kubernetes config: %v", err)
-		}
+		return errors.Wrap(err, "failed to load clientset")
 	}
-
-	clientset, err := kubernetes.NewForConfig(config)
+	d, err := dynamic.NewForConfig(cfg)
 	if err != nil {
-		log.Fatalf("failed to load clientset: %v", err)
+		return errors.Wrap(err, "failed to load dynamic client")
 	}
+	clientset, dynamicClient = c, d
+	return nil
+}
 
-	return clientset
-}()
+func GetSecret(namespace, name string) (*corev1.Secret, error) {
+	return clientset.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+}
 
 func CreateSecret(namespace string, secret *corev1.Secret) (*corev1.Secret, error) {
 	return clientset.CoreV1().Secrets(namespace).Create(secret)
@@ -53,18 +50,18 @@ func DeleteSecretCollection(namespace string, options *metav1.DeleteOptions, lis
 	return clientset.CoreV1().Secrets(namespace).DeleteCollection(options, listOptions)
 }
 
-func GetAllNamespaceSecrets() ([]corev1.Secret, error) {
+func ListSecrets(namespace string, listOptions metav1.ListOptions) ([]corev1.Secret, error) {
+	seclist, err := clientset.CoreV1().Secrets(namespace).List(listOptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get Secret list in [namespace:%s]", namespace)
+	}
+	return seclist.Items, nil
+}
+
+func GetNamespaces() ([]corev1.Namespace, error) {
 	nslist, err := clientset.CoreV1().Namespaces().List(metav1.ListOptions{})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get Namespace list")
 	}
-	ret := []corev1.Secret{}
-	for _, ns := range nslist.Items {
-		seclist, err := clientset.CoreV1().Secrets(ns.Name).List(metav1.ListOptions{})
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to get Secret list in [namespace:%s]", ns.Name)
-		}
-		ret = append(ret, seclist.Items...)
-	}
-	return ret, nil
+	return nslist.Items, nil
 }
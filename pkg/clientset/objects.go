@@ -0,0 +1,253 @@
+package clientset
+
+import (
+	"fmt"
+	"reflect"
+
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// ObjectInterface reads and writes the objects of a kind in the shape of Secrets,
+// see riggertypes.SecretFromConfigMap and riggertypes.SecretFromUnstructured.
+type ObjectInterface interface {
+	Get(namespace, name string) (*corev1.Secret, error)
+	Create(namespace string, obj *corev1.Secret) (*corev1.Secret, error)
+	Update(namespace string, obj *corev1.Secret) (*corev1.Secret, error)
+	Delete(namespace, name string, options *metav1.DeleteOptions) error
+	List(namespace string, listOptions metav1.ListOptions) ([]corev1.Secret, error)
+}
+
+// resources maps the kinds registered to sync to their resources, and resourceKinds keeps them in order.
+var resources = map[schema.GroupVersionKind]schema.GroupVersionResource{}
+var resourceKinds []schema.GroupVersionKind
+
+// RegisterResource configures the objects of gvk, served as the resource gvr, to sync through the dynamic client.
+// It must be called before the controllers are added to the manager.
+func RegisterResource(gvk schema.GroupVersionKind, gvr schema.GroupVersionResource) {
+	if gvk == riggertypes.SecretGroupVersionKind || gvk == riggertypes.ConfigMapGroupVersionKind {
+		return
+	}
+	if _, ok := resources[gvk]; !ok {
+		resourceKinds = append(resourceKinds, gvk)
+	}
+	resources[gvk] = gvr
+}
+
+// SyncKinds returns the kinds to sync, which are Secret, ConfigMap and the registered kinds in order.
+func SyncKinds() []schema.GroupVersionKind {
+	return append([]schema.GroupVersionKind{riggertypes.SecretGroupVersionKind, riggertypes.ConfigMapGroupVersionKind}, resourceKinds...)
+}
+
+// IsSyncKind reports whether the objects of gvk are configured to sync.
+func IsSyncKind(gvk schema.GroupVersionKind) bool {
+	if gvk == riggertypes.SecretGroupVersionKind || gvk == riggertypes.ConfigMapGroupVersionKind {
+		return true
+	}
+	_, ok := resources[gvk]
+	return ok
+}
+
+// Objects returns the ObjectInterface of the objects of gvk.
+// The objects of a kind not configured to sync fail every operation.
+func Objects(gvk schema.GroupVersionKind) ObjectInterface {
+	switch gvk {
+	case riggertypes.SecretGroupVersionKind:
+		return secrets{}
+	case riggertypes.ConfigMapGroupVersionKind:
+		return configMaps{}
+	}
+	if gvr, ok := resources[gvk]; ok {
+		return unstructuredObjects{gvk: gvk, resource: dynamicClient.Resource(gvr)}
+	}
+	return unconfiguredObjects{gvk: gvk}
+}
+
+type secrets struct{}
+
+func (secrets) Get(namespace, name string) (*corev1.Secret, error) {
+	return GetSecret(namespace, name)
+}
+
+func (secrets) Create(namespace string, obj *corev1.Secret) (*corev1.Secret, error) {
+	return CreateSecret(namespace, obj)
+}
+
+func (secrets) Update(namespace string, obj *corev1.Secret) (*corev1.Secret, error) {
+	return UpdateSecret(namespace, obj)
+}
+
+func (secrets) Delete(namespace, name string, options *metav1.DeleteOptions) error {
+	return DeleteSecret(namespace, name, options)
+}
+
+func (secrets) List(namespace string, listOptions metav1.ListOptions) ([]corev1.Secret, error) {
+	return ListSecrets(namespace, listOptions)
+}
+
+type configMaps struct{}
+
+func (configMaps) Get(namespace, name string) (*corev1.Secret, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return riggertypes.SecretFromConfigMap(cm), nil
+}
+
+func (configMaps) Create(namespace string, obj *corev1.Secret) (*corev1.Secret, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Create(riggertypes.ConfigMapFromSecret(obj))
+	if err != nil {
+		return nil, err
+	}
+	return riggertypes.SecretFromConfigMap(cm), nil
+}
+
+func (configMaps) Update(namespace string, obj *corev1.Secret) (*corev1.Secret, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Update(riggertypes.ConfigMapFromSecret(obj))
+	if err != nil {
+		return nil, err
+	}
+	return riggertypes.SecretFromConfigMap(cm), nil
+}
+
+func (configMaps) Delete(namespace, name string, options *metav1.DeleteOptions) error {
+	return clientset.CoreV1().ConfigMaps(namespace).Delete(name, options)
+}
+
+func (configMaps) List(namespace string, listOptions metav1.ListOptions) ([]corev1.Secret, error) {
+	cmlist, err := clientset.CoreV1().ConfigMaps(namespace).List(listOptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get ConfigMap list in [namespace:%s]", namespace)
+	}
+	ret := make([]corev1.Secret, 0, len(cmlist.Items))
+	for i := range cmlist.Items {
+		ret = append(ret, *riggertypes.SecretFromConfigMap(&cmlist.Items[i]))
+	}
+	return ret, nil
+}
+
+type unstructuredObjects struct {
+	gvk      schema.GroupVersionKind
+	resource dynamic.NamespaceableResourceInterface
+}
+
+func (o unstructuredObjects) Get(namespace, name string) (*corev1.Secret, error) {
+	u, err := o.resource.Namespace(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return riggertypes.SecretFromUnstructured(u)
+}
+
+func (o unstructuredObjects) Create(namespace string, obj *corev1.Secret) (*corev1.Secret, error) {
+	u, err := riggertypes.UnstructuredFromSecret(obj, o.gvk)
+	if err != nil {
+		return nil, err
+	}
+	created, err := o.resource.Namespace(namespace).Create(u, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	created, err = o.updateStatus(namespace, u, created)
+	if err != nil {
+		return nil, err
+	}
+	return riggertypes.SecretFromUnstructured(created)
+}
+
+func (o unstructuredObjects) Update(namespace string, obj *corev1.Secret) (*corev1.Secret, error) {
+	u, err := riggertypes.UnstructuredFromSecret(obj, o.gvk)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike Secrets, custom resources are never updated unconditionally.
+	if u.GetResourceVersion() == "" {
+		existing, err := o.resource.Namespace(namespace).Get(u.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		u.SetResourceVersion(existing.GetResourceVersion())
+	}
+	updated, err := o.resource.Namespace(namespace).Update(u, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	updated, err = o.updateStatus(namespace, u, updated)
+	if err != nil {
+		return nil, err
+	}
+	return riggertypes.SecretFromUnstructured(updated)
+}
+
+// updateStatus writes the status of desired to the written object, since a resource with the status subresource
+// ignores the status on create and update. The written object is returned as is if the status is up to date.
+func (o unstructuredObjects) updateStatus(namespace string, desired, written *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	status, ok := desired.Object["status"]
+	if !ok || reflect.DeepEqual(status, written.Object["status"]) {
+		return written, nil
+	}
+	written = written.DeepCopy()
+	written.Object["status"] = status
+	updated, err := o.resource.Namespace(namespace).UpdateStatus(written, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		// The resource has no status subresource, so the status has already been written.
+		return written, nil
+	}
+	return updated, err
+}
+
+func (o unstructuredObjects) Delete(namespace, name string, options *metav1.DeleteOptions) error {
+	return o.resource.Namespace(namespace).Delete(name, options)
+}
+
+func (o unstructuredObjects) List(namespace string, listOptions metav1.ListOptions) ([]corev1.Secret, error) {
+	ulist, err := o.resource.Namespace(namespace).List(listOptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s list in [namespace:%s]", o.gvk.Kind, namespace)
+	}
+	ret := make([]corev1.Secret, 0, len(ulist.Items))
+	for i := range ulist.Items {
+		secret, err := riggertypes.SecretFromUnstructured(&ulist.Items[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to convert %s [namespace:%s,name:%s]", o.gvk.Kind, ulist.Items[i].GetNamespace(), ulist.Items[i].GetName())
+		}
+		ret = append(ret, *secret)
+	}
+	return ret, nil
+}
+
+type unconfiguredObjects struct {
+	gvk schema.GroupVersionKind
+}
+
+func (o unconfiguredObjects) err() error {
+	return fmt.Errorf("%s is not configured to sync, see --sync-resources of the manager", o.gvk)
+}
+
+func (o unconfiguredObjects) Get(namespace, name string) (*corev1.Secret, error) {
+	return nil, o.err()
+}
+
+func (o unconfiguredObjects) Create(namespace string, obj *corev1.Secret) (*corev1.Secret, error) {
+	return nil, o.err()
+}
+
+func (o unconfiguredObjects) Update(namespace string, obj *corev1.Secret) (*corev1.Secret, error) {
+	return nil, o.err()
+}
+
+func (o unconfiguredObjects) Delete(namespace, name string, options *metav1.DeleteOptions) error {
+	return o.err()
+}
+
+func (o unconfiguredObjects) List(namespace string, listOptions metav1.ListOptions) ([]corev1.Secret, error) {
+	return nil, o.err()
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"github.com/wantedly/rigger/pkg/controller/dstsecret"
+	"github.com/wantedly/rigger/pkg/controller/namespace"
 	"github.com/wantedly/rigger/pkg/controller/plan"
 	"github.com/wantedly/rigger/pkg/controller/srcsecret"
 )
@@ -11,4 +12,5 @@ func init() {
 	AddToManagerFuncs = append(AddToManagerFuncs, plan.Add)
 	AddToManagerFuncs = append(AddToManagerFuncs, srcsecret.Add)
 	AddToManagerFuncs = append(AddToManagerFuncs, dstsecret.Add)
+	AddToManagerFuncs = append(AddToManagerFuncs, namespace.Add)
 }
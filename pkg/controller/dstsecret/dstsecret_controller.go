@@ -3,7 +3,8 @@ package dstsecret
 import (
 	"context"
 	"fmt"
-	"reflect"
+	"strings"
+	"sync"
 
 	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
 	"github.com/wantedly/rigger/pkg/clientset"
@@ -13,10 +14,14 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -26,27 +31,44 @@ import (
 
 var log = logf.Log.WithName("dst-secret-controller")
 
-// Add creates a new Secret Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
-// and Start it when the Manager is Started.
+// Add creates a new Controller for each kind to sync, such as Secret and ConfigMap, and adds them to the Manager with default RBAC.
+// The Manager will set fields on the Controllers and Start them when the Manager is Started.
 func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+	for _, gvk := range clientset.SyncKinds() {
+		name := fmt.Sprintf("dst-%s-controller", strings.ToLower(gvk.GroupKind().String()))
+		if err := add(mgr, name, util.NewSyncObject(gvk), newReconciler(mgr, gvk)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileDstSecret{Client: mgr.GetClient(), scheme: mgr.GetScheme()}
+// newReconciler returns a new reconcile.Reconciler of the objects of gvk
+func newReconciler(mgr manager.Manager, gvk schema.GroupVersionKind) *ReconcileDstSecret {
+	return &ReconcileDstSecret{Client: mgr.GetClient(), scheme: mgr.GetScheme(), gvk: gvk, deleted: map[types.NamespacedName]*corev1.Secret{}}
 }
 
-// add adds a new Controller to mgr with r as the reconcile.Reconciler
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
+// add adds a new Controller named name to mgr with r as the reconcile.Reconciler of the objects of the type of obj
+func add(mgr manager.Manager, name string, obj runtime.Object, r *ReconcileDstSecret) error {
 	// Create a new controller
-	c, err := controller.New("dst-secret-controller", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New(name, mgr, controller.Options{Reconciler: r})
 	if err != nil {
 		return err
 	}
 
-	// Watch for changes to Secret
-	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{})
+	// Watch for changes to the objects to sync, remembering the synced ones deleted
+	enqueue := &handler.EnqueueRequestForObject{}
+	err = c.Watch(&source.Kind{Type: obj}, handler.Funcs{
+		CreateFunc:  enqueue.Create,
+		UpdateFunc:  enqueue.Update,
+		GenericFunc: enqueue.Generic,
+		DeleteFunc: func(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			if e.Meta != nil {
+				r.rememberDeleted(e.Meta)
+			}
+			enqueue.Delete(e, q)
+		},
+	})
 	if err != nil {
 		return err
 	}
@@ -56,21 +78,54 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 
 var _ reconcile.Reconciler = &ReconcileDstSecret{}
 
-// ReconcileSecret reconciles a Secret object
+// ReconcileSecret reconciles a Secret object, or an object of another kind in the shape of a Secret
 type ReconcileDstSecret struct {
 	client.Client
 	scheme *runtime.Scheme
+	// gvk is the kind of the objects reconciled, only the plans of which are followed.
+	gvk schema.GroupVersionKind
+
+	// deleted holds the metadata of the synced secrets deleted until they are reconciled, since they are no longer
+	// in the cache but their annotations tell the plans and the sources which restore them.
+	deletedMu sync.Mutex
+	deleted   map[types.NamespacedName]*corev1.Secret
+}
+
+// rememberDeleted remembers the metadata of the deleted object if it has been synced by rigger.
+func (r *ReconcileDstSecret) rememberDeleted(meta metav1.Object) {
+	last := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   meta.GetNamespace(),
+		Name:        meta.GetName(),
+		Labels:      meta.GetLabels(),
+		Annotations: meta.GetAnnotations(),
+	}}
+	if !riggertypes.IsDstSecret(last) {
+		return
+	}
+	r.deletedMu.Lock()
+	defer r.deletedMu.Unlock()
+	r.deleted[types.NamespacedName{Namespace: last.Namespace, Name: last.Name}] = last
+}
+
+// forgetDeleted returns the metadata of the deleted secret of key in the shape of a secret, and forgets it.
+func (r *ReconcileDstSecret) forgetDeleted(key types.NamespacedName) (*corev1.Secret, bool) {
+	r.deletedMu.Lock()
+	defer r.deletedMu.Unlock()
+	last, ok := r.deleted[key]
+	delete(r.deleted, key)
+	return last, ok
 }
 
 // Reconcile reads that state of the cluster for a Secret object and makes changes based on the state read
 // and what is in the Secret.Spec
-// Automatically generate RBAC rules to allow the Controller to read and write Secrets
+// Automatically generate RBAC rules to allow the Controller to read and write Secrets and ConfigMaps
 // +kubebuilder:rbac:groups=cores,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 func (r *ReconcileDstSecret) Reconcile(request reconcile.Request) (reconcile.Result, error) {
 	// Fetch the Secret instance
-	dstSecret, dstSecretDeleted, err := util.ReconcilesFetchSecret(r, context.TODO(), request.NamespacedName)
+	dstSecret, dstSecretDeleted, err := util.ReconcilesFetchObject(r, context.TODO(), r.gvk, request.NamespacedName)
 	if err != nil {
-		return reconcile.Result{}, fmt.Errorf("failed to get secret %s", request.NamespacedName.String())
+		return reconcile.Result{}, fmt.Errorf("failed to get %s %s", r.gvk, request.NamespacedName.String())
 	}
 	dstSecretExists := !dstSecretDeleted
 
@@ -78,41 +133,61 @@ func (r *ReconcileDstSecret) Reconcile(request reconcile.Request) (reconcile.Res
 	dstNamespace := request.NamespacedName.Namespace
 	dstName := riggertypes.DstSecretName(request.NamespacedName.Name)
 
-	var srcNamespace string
-	var srcName string
 	// Verify that the Secret is sync target.
+	// The deleted Secret is restored from the metadata it had, which the deletion event told.
+	meta := dstSecret
 	if dstSecretDeleted {
-		var ok bool
-		srcNamespace, srcName, ok = dstName.Split()
+		last, ok := r.forgetDeleted(request.NamespacedName)
 		if !ok {
+			// The secret was deleted while the manager was down, which the plan-controller restores as it starts.
 			return reconcile.Result{}, nil
 		}
-		found := false
-		plan.Cache.Range(func(name, plan interface{}) bool {
-			pl := plan.(*riggerv1beta1.Plan)
-			if pl.Spec.SyncDestNamespace == dstNamespace && pl.Spec.SyncTargetSecretName == srcName && !util.Contains(srcNamespace, pl.Spec.IgnoreNamespaces) {
-				found = true
-				return false
-			}
-			return true // continue
-		})
-		if !found {
+		meta = last
+	}
+	if !riggertypes.IsDstSecret(meta) {
+		return reconcile.Result{}, nil
+	}
+	planKey, ok := riggertypes.GetDstSecretPlan(meta)
+	if !ok {
+		// Secrets synced before plan labels were introduced are left to the src-secret-controller.
+		return reconcile.Result{}, nil
+	}
+	pl, planDeleted, err := util.ReconcilesFetchPlan(r, context.TODO(), planKey)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get plan %s", planKey)
+	}
+	if planDeleted || pl.GetSpec().GetGroupVersionKind() != r.gvk {
+		// The finalizer of the plan deletes the synced secrets, and so does the plan-controller as the kind changes.
+		return reconcile.Result{}, nil
+	}
+	switch pl.GetSpec().GetMode() {
+	case riggerv1beta1.PlanModeMerge:
+		if dstNamespace != pl.GetSpec().SyncDestNamespace || dstName.String() != util.MergedSecretName(pl) {
+			// The plan-controller prunes the secrets merged under an old name.
+			return reconcile.Result{}, nil
+		}
+		return r.merge(pl)
+	case riggerv1beta1.PlanModeDistribute:
+		if dstSecretDeleted && !r.isDistributed(pl, dstNamespace, dstName) {
 			return reconcile.Result{}, nil
 		}
-	} else {
-		if dstSecret.Labels[riggertypes.DstSecretLabelCreatedByRiggerKey] != riggertypes.DstSecretLabelCreatedByRiggerValue {
+	default:
+		if dstSecretDeleted && dstNamespace != pl.GetSpec().SyncDestNamespace {
 			return reconcile.Result{}, nil
 		}
-		srcNamespace = dstSecret.Labels[riggertypes.DstSecretLabelSrcNamespaceKey]
-		srcName = dstSecret.Labels[riggertypes.DstSecretLabelSrcNameKey]
 	}
+	srcNamespace, srcName, ok := riggertypes.GetSrcSecret(meta)
+	if !ok {
+		return reconcile.Result{}, nil
+	}
+	opts := riggertypes.NewDstSecretOptions(pl.GetSpec())
 
 	// Following is operation for sync target.
 
 	// ignore にいるやつを削除する的なことはしなくていいんだっけ
 	// なんかログ内のNamespaceの表記揺れがひどい
 
-	srcSecret, srcSecretNotFound, err := util.ReconcilesFetchSecret(r, context.TODO(), types.NamespacedName{Namespace: srcNamespace, Name: srcName})
+	srcSecret, srcSecretNotFound, err := util.ReconcilesFetchObject(r, context.TODO(), r.gvk, types.NamespacedName{Namespace: srcNamespace, Name: srcName})
 	if err != nil {
 		return reconcile.Result{}, fmt.Errorf("failed to get secret [namespace:%s,name:%s]", srcNamespace, srcName)
 	}
@@ -120,40 +195,123 @@ func (r *ReconcileDstSecret) Reconcile(request reconcile.Request) (reconcile.Res
 
 	switch {
 	case srcSecretExists && dstSecretDeleted:
-		// Create destination Secret
-		ds := riggertypes.NewDstSecret(dstNamespace, dstName, srcSecret)
-		_, err := clientset.CreateSecret(dstNamespace, ds)
+		// Create destination Secret unless the plan no longer syncs the source to it.
+		if pl.GetSpec().GetMode() == riggerv1beta1.PlanModeCollect && !r.isCollected(pl, srcSecret, dstName) {
+			return reconcile.Result{}, nil
+		}
+		ds := riggertypes.NewDstSecret(planKey, dstNamespace, dstName, srcSecret, opts)
+		created, err := clientset.Objects(r.gvk).Create(dstNamespace, ds)
 		if apierrors.IsAlreadyExists(err) {
 			log.Info(fmt.Sprintf("tried to create a secret, but it already exists [namespace%s,name:%s]", dstNamespace, dstName))
 		} else if err != nil {
+			plan.RecordSyncFailed(pl, srcNamespace, err)
 			return reconcile.Result{}, fmt.Errorf("failed to create secret [namespace:%s,name:%s]", dstNamespace, dstName)
 		} else {
 			log.Info(fmt.Sprintf("succeeded to create secret [namespace:%s,name:%s]", dstNamespace, dstName))
+			plan.RecordSecretCreated(pl, created)
 		}
 	case srcSecretExists && dstSecretExists:
 		// Update destination Secret
-		if reflect.DeepEqual(srcSecret.Data, dstSecret.Data) {
+		ds := riggertypes.NewDstSecret(planKey, dstNamespace, dstName, srcSecret, opts)
+		if riggertypes.IsDstSecretUpToDate(dstSecret, ds) {
 			return reconcile.Result{}, nil
 		}
-		ds := riggertypes.NewDstSecret(dstNamespace, dstName, srcSecret)
-		_, err := clientset.UpdateSecret(dstNamespace, ds)
+		updated, err := clientset.Objects(r.gvk).Update(dstNamespace, ds)
 		if apierrors.IsNotFound(err) {
 			log.Info(fmt.Sprintf("tried to update a secret, but it not found [namespace:%s,name:%s]", dstNamespace, dstName))
 		} else if err != nil {
+			plan.RecordSyncFailed(pl, srcNamespace, err)
 			return reconcile.Result{}, fmt.Errorf("failed to update secret [namespace:%s,name:%s]", dstNamespace, dstName)
 		} else {
 			log.Info(fmt.Sprintf("succeeded to update secret [namespace:%s,name:%s]", dstNamespace, dstName))
+			plan.RecordSecretUpdated(pl, updated)
 		}
 	case srcSecretNotFound && dstSecretExists:
 		// Delete destination Secret
-		err := clientset.DeleteSecret(dstNamespace, dstName.String(), nil)
+		err := clientset.Objects(r.gvk).Delete(dstNamespace, dstName.String(), nil)
 		if apierrors.IsNotFound(err) {
 			log.Info(fmt.Sprintf("tried to delete a secret, but it not found [namespace:%s,name:%s]", dstNamespace, dstName))
 		} else if err != nil {
 			log.Error(err, fmt.Sprintf("failed to delete secret [namespace:%s,name:%s]", dstNamespace, dstName))
 		} else {
 			log.Info(fmt.Sprintf("succeeded to delete secret [namespace:%s,name:%s]", dstNamespace, dstName))
+			plan.RecordSecretPruned(pl, dstNamespace, dstName.String())
 		}
 	}
 	return reconcile.Result{}, nil
 }
+
+// merge restores the secret into which the plan in Merge mode merges the target secrets.
+func (r *ReconcileDstSecret) merge(pl riggerv1beta1.PlanObject) (reconcile.Result, error) {
+	planKey := types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}
+	_, err := plan.MergeSecrets(r, pl)
+	if e := plan.UpdateRenderedCondition(r, pl, err); e != nil {
+		log.Error(e, fmt.Sprintf("failed to report rendering of merged secret [plan:%s]", planKey))
+	}
+	if err != nil {
+		plan.RecordSyncFailed(pl, pl.GetSpec().SyncDestNamespace, err)
+		return reconcile.Result{}, fmt.Errorf("failed to merge secrets [namespace:%s,name:%s]", pl.GetSpec().SyncDestNamespace, util.MergedSecretName(pl))
+	}
+	return reconcile.Result{}, nil
+}
+
+// isCollected reports whether dstName is the name of the secret which the plan in Collect mode syncs from srcSecret.
+func (r *ReconcileDstSecret) isCollected(pl riggerv1beta1.PlanObject, srcSecret *corev1.Secret, dstName riggertypes.DstSecretName) bool {
+	if !util.IsSyncSource(types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}, pl.GetSpec().SyncDestNamespace, srcSecret) {
+		return false
+	}
+	namespace, namespaceDeleted, err := util.ReconcilesFetchNamespace(r, context.TODO(), srcSecret.Namespace)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to get namespace %s", srcSecret.Namespace))
+		return false
+	}
+	if namespaceDeleted {
+		return false
+	}
+	matched, err := util.NewNamespaceFilter(pl).Matches(namespace)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to match namespace [namespace:%s]", srcSecret.Namespace))
+		return false
+	}
+	if !matched {
+		return false
+	}
+	// The secret is synced for the first target it matches.
+	target, err := util.FindSyncTarget(pl.GetSpec().GetSyncTargets(), srcSecret)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to match secret [namespace:%s,name:%s]", srcSecret.Namespace, srcSecret.Name))
+		return false
+	}
+	if target == nil {
+		return false
+	}
+	// The name differs if the prefix of the target or the template has been changed.
+	name, err := riggertypes.NewDstSecretOptions(pl.GetSpec()).Name(target.DestNamePrefix, srcSecret)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to name synced secret [namespace:%s,name:%s]", srcSecret.Namespace, srcSecret.Name))
+		return false
+	}
+	return name == dstName
+}
+
+// isDistributed reports whether dstName in dstNamespace is the name of the secret which the plan in Distribute mode syncs.
+func (r *ReconcileDstSecret) isDistributed(pl riggerv1beta1.PlanObject, dstNamespace string, dstName riggertypes.DstSecretName) bool {
+	source := pl.GetSpec().Source
+	if source == nil || source.Name != dstName.String() || source.Namespace == dstNamespace {
+		return false
+	}
+	namespace, namespaceDeleted, err := util.ReconcilesFetchNamespace(r, context.TODO(), dstNamespace)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to get namespace %s", dstNamespace))
+		return false
+	}
+	if namespaceDeleted {
+		return false
+	}
+	matched, err := util.NewNamespaceFilter(pl).Matches(namespace)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to match namespace [namespace:%s]", dstNamespace))
+		return false
+	}
+	return matched
+}
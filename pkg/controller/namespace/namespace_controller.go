@@ -0,0 +1,230 @@
+package namespace
+
+import (
+	"context"
+	"fmt"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	planctrl "github.com/wantedly/rigger/pkg/controller/plan"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+	"github.com/wantedly/rigger/pkg/util"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("namespace-controller")
+
+// Add creates a new Namespace Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileNamespace{Client: mgr.GetClient(), scheme: mgr.GetScheme()}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("namespace-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to Namespace
+	err = c.Watch(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileNamespace{}
+
+// ReconcileNamespace reconciles a Namespace object
+type ReconcileNamespace struct {
+	client.Client
+	scheme *runtime.Scheme
+}
+
+// Reconcile re-evaluates whether the Namespace is a sync source or destination of each Plan, since its labels may have changed,
+// and syncs or deletes the secrets of the Namespace accordingly. New Namespaces receive distributed secrets here.
+// Automatically generate RBAC rules to allow the Controller to read Namespaces
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+func (r *ReconcileNamespace) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	// Fetch the Namespace instance
+	namespace, namespaceDeleted, err := util.ReconcilesFetchNamespace(r, context.TODO(), request.NamespacedName.Name)
+	if namespaceDeleted {
+		// The src-secret-controller deletes the synced secrets as the secrets of the Namespace are deleted.
+		return reconcile.Result{}, nil
+	} else if err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "failed to get namespace %s", request.NamespacedName.Name)
+	}
+
+	var syncErr error
+	err = planctrl.Cache.Range(func(pl riggerv1beta1.PlanObject) bool {
+		planKey := types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}
+		targets := pl.GetSpec().GetSyncTargets()
+		dstNamespace := pl.GetSpec().SyncDestNamespace
+		gvk := pl.GetSpec().GetGroupVersionKind()
+		matched, err := util.NewNamespaceFilter(pl).Matches(namespace)
+		if err != nil {
+			log.Error(err, fmt.Sprintf("failed to match namespace [namespace:%s,plan:%s]", namespace.Name, planKey))
+			return true // continue
+		}
+		if pl.GetSpec().GetMode() == riggerv1beta1.PlanModeMerge {
+			if err := r.reconcileMerge(pl, namespace, matched); err != nil {
+				syncErr = err
+				return false
+			}
+			return true // continue
+		}
+		if pl.GetSpec().GetMode() == riggerv1beta1.PlanModeDistribute {
+			if err := r.reconcileDistribution(pl, namespace, matched); err != nil {
+				syncErr = err
+				return false
+			}
+			return true // continue
+		}
+
+		// Look up the synced secrets in the informer cache to avoid needless writes.
+		labels := riggertypes.NewPlanDstSecretLabels(planKey)
+		labels[riggertypes.DstSecretLabelSrcNamespaceKey] = namespace.Name
+		dstSecrets, err := util.ReconcilesListObjects(r, context.TODO(), gvk, client.InNamespace(dstNamespace).MatchingLabels(labels))
+		if err != nil {
+			syncErr = errors.Wrapf(err, "failed to list secrets [namespace:%s,selector:%s]", dstNamespace, labels.GetLabelSelector())
+			return false
+		}
+		synced := len(dstSecrets) > 0
+
+		switch {
+		case matched && !synced:
+			srcSecrets, err := util.ReconcilesListObjects(r, context.TODO(), gvk, client.InNamespace(namespace.Name))
+			if err != nil {
+				syncErr = errors.Wrapf(err, "failed to list secrets [namespace:%s]", namespace.Name)
+				return false
+			}
+			found := false
+			for i := range srcSecrets {
+				target, err := util.FindSyncTarget(targets, &srcSecrets[i])
+				if err != nil {
+					log.Error(err, fmt.Sprintf("failed to match secret [namespace:%s,name:%s,plan:%s]", namespace.Name, srcSecrets[i].Name, planKey))
+					return true // continue
+				}
+				if target != nil {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return true // continue
+			}
+			if err := planctrl.SyncNamespaceSecrets(pl, targets, riggertypes.NewDstSecretOptions(pl.GetSpec()), dstNamespace, util.NewNamespaceFilter(pl), namespace); err != nil {
+				syncErr = err
+				return false
+			}
+		case !matched && synced:
+			if err := planctrl.DeleteNamespaceSyncedSecrets(pl, gvk, dstNamespace, namespace.Name); err != nil {
+				syncErr = err
+				return false
+			}
+		}
+		return true // continue
+	})
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if syncErr != nil {
+		return reconcile.Result{}, syncErr
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// reconcileMerge rebuilds the secret into which the plan in Merge mode merges the target secrets
+// if the namespace has been selected and has the target secrets, or unselected and has been merged.
+func (r *ReconcileNamespace) reconcileMerge(pl riggerv1beta1.PlanObject, namespace *corev1.Namespace, matched bool) error {
+	planKey := types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}
+	sources, err := planctrl.GetMergedSources(r, pl)
+	if err != nil {
+		return err
+	}
+	merged := sources.HasNamespace(namespace.Name)
+
+	if matched == merged {
+		return nil
+	}
+	if matched {
+		srcSecrets, err := util.ReconcilesListObjects(r, context.TODO(), pl.GetSpec().GetGroupVersionKind(), client.InNamespace(namespace.Name))
+		if err != nil {
+			return errors.Wrapf(err, "failed to list secrets [namespace:%s]", namespace.Name)
+		}
+		found := false
+		for i := range srcSecrets {
+			target, err := util.FindSyncTarget(pl.GetSpec().GetSyncTargets(), &srcSecrets[i])
+			if err != nil {
+				return errors.Wrapf(err, "failed to match secret [namespace:%s,name:%s,plan:%s]", namespace.Name, srcSecrets[i].Name, planKey)
+			}
+			if target != nil && util.IsSyncSource(planKey, pl.GetSpec().SyncDestNamespace, &srcSecrets[i]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	_, err = planctrl.MergeSecrets(r, pl)
+	if e := planctrl.UpdateRenderedCondition(r, pl, err); e != nil {
+		log.Error(e, fmt.Sprintf("failed to report rendering of merged secret [plan:%s]", planKey))
+	}
+	return err
+}
+
+// reconcileDistribution syncs the source secret of the plan in Distribute mode to the namespace if matched,
+// or deletes the synced secret from the namespace otherwise.
+func (r *ReconcileNamespace) reconcileDistribution(pl riggerv1beta1.PlanObject, namespace *corev1.Namespace, matched bool) error {
+	planKey := types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}
+	gvk := pl.GetSpec().GetGroupVersionKind()
+	source := pl.GetSpec().Source
+	if source == nil || source.Namespace == namespace.Name {
+		return nil
+	}
+
+	// Look up the synced secret in the informer cache to avoid needless writes.
+	labels := riggertypes.NewPlanDstSecretLabels(planKey)
+	dstSecrets, err := util.ReconcilesListObjects(r, context.TODO(), gvk, client.InNamespace(namespace.Name).MatchingLabels(labels))
+	if err != nil {
+		return errors.Wrapf(err, "failed to list secrets [namespace:%s,selector:%s]", namespace.Name, labels.GetLabelSelector())
+	}
+	synced := len(dstSecrets) > 0
+
+	switch {
+	case matched && !synced:
+		srcSecret, srcSecretNotFound, err := util.ReconcilesFetchObject(r, context.TODO(), gvk, types.NamespacedName{Namespace: source.Namespace, Name: source.Name})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get secret [namespace:%s,name:%s]", source.Namespace, source.Name)
+		}
+		if srcSecretNotFound {
+			return nil
+		}
+		_, err = planctrl.DistributeNamespaceSecret(pl, srcSecret, riggertypes.NewDstSecretOptions(pl.GetSpec()), util.NewNamespaceFilter(pl), namespace)
+		return err
+	case !matched && synced:
+		return planctrl.DeleteSyncedSecrets(pl, gvk, namespace.Name)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package namespace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	"github.com/wantedly/rigger/pkg/clientset"
+	planctrl "github.com/wantedly/rigger/pkg/controller/plan"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const timeout = time.Second * 10
+
+// secretExists returns the function reporting whether the secret of namespace and name exists, for Eventually.
+func secretExists(namespace, name string) func() bool {
+	return func() bool {
+		_, err := clientset.Objects(riggertypes.SecretGroupVersionKind).Get(namespace, name)
+		return err == nil
+	}
+}
+
+func TestReconcileFollowsNamespaceLabels(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	// The plan-controller fills the cache of plans which the namespace-controller follows.
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(planctrl.Add(mgr)).NotTo(gomega.HaveOccurred())
+	g.Expect(Add(mgr)).NotTo(gomega.HaveOccurred())
+	c, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	stop, wg := StartTestManager(mgr, g)
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	g.Expect(c.Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "label-dst"}})).NotTo(gomega.HaveOccurred())
+	g.Expect(c.Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "label-src"}})).NotTo(gomega.HaveOccurred())
+	srcSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "label-src", Name: "token"},
+		Data:       map[string][]byte{"token": []byte("label-src")},
+	}
+	g.Expect(c.Create(context.TODO(), srcSecret)).NotTo(gomega.HaveOccurred())
+
+	instance := &riggerv1beta1.ClusterPlan{
+		ObjectMeta: metav1.ObjectMeta{Name: "label"},
+		Spec: riggerv1beta1.PlanSpec{
+			SyncTargetSecretName: "token",
+			SyncDestNamespace:    "label-dst",
+			NamespaceSelector:    &metav1.LabelSelector{MatchLabels: map[string]string{"rigger-test": "label"}},
+		},
+	}
+	g.Expect(c.Create(context.TODO(), instance)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), instance)
+
+	copyName := riggertypes.NewDstSecretName("label-src", "token").String()
+	g.Consistently(secretExists("label-dst", copyName), time.Second).Should(gomega.BeFalse())
+
+	// Labeling the namespace selects it as a source, and unlabeling it unselects it.
+	setLabels := func(labels map[string]string) {
+		g.Eventually(func() error {
+			namespace := &corev1.Namespace{}
+			if err := c.Get(context.TODO(), types.NamespacedName{Name: "label-src"}, namespace); err != nil {
+				return err
+			}
+			namespace.Labels = labels
+			return c.Update(context.TODO(), namespace)
+		}, timeout).Should(gomega.Succeed())
+	}
+	setLabels(map[string]string{"rigger-test": "label"})
+	g.Eventually(secretExists("label-dst", copyName), timeout).Should(gomega.BeTrue())
+	setLabels(nil)
+	g.Eventually(secretExists("label-dst", copyName), timeout).Should(gomega.BeFalse())
+}
@@ -1,34 +1,68 @@
 package plan
 
 import (
-	"sync"
+	"context"
 
 	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// Cache is backed by the shared informer of the manager, so it holds every Plan and ClusterPlan on the API server
+// once the informer has synced, even if the Plan has not been reconciled yet.
 var Cache = &cache{}
 
-// Data set of secret resource
+// Data set of plan resource
 type cache struct {
-	sm sync.Map
+	reader client.Reader
 }
 
-func (s *cache) Store(name string, plan *riggerv1beta1.Plan) {
-	s.sm.Store(name, plan)
+func (s *cache) setReader(reader client.Reader) {
+	s.reader = reader
 }
 
-func (s *cache) Load(name string) (*riggerv1beta1.Plan, bool) {
-	v, ok := s.sm.Load(name)
-	if !ok {
-		return nil, false
+// Range calls f sequentially for each ClusterPlan and Plan which is not being deleted and stays in its scope.
+// If f returns false, Range stops the iteration.
+func (s *cache) Range(f func(plan riggerv1beta1.PlanObject) bool) error {
+	// The informer cache waits for the informers to sync before listing.
+	clusterPlans := &riggerv1beta1.ClusterPlanList{}
+	if err := s.reader.List(context.TODO(), &client.ListOptions{}, clusterPlans); err != nil {
+		return errors.Wrap(err, "failed to list clusterplans")
 	}
-	return v.(*riggerv1beta1.Plan), ok
-}
-
-func (s *cache) Range(f func(name, plan interface{}) bool) {
-	s.sm.Range(f)
+	plans := &riggerv1beta1.PlanList{}
+	if err := s.reader.List(context.TODO(), &client.ListOptions{}, plans); err != nil {
+		return errors.Wrap(err, "failed to list plans")
+	}
+	objs := make([]riggerv1beta1.PlanObject, 0, len(clusterPlans.Items)+len(plans.Items))
+	for i := range clusterPlans.Items {
+		objs = append(objs, &clusterPlans.Items[i])
+	}
+	for i := range plans.Items {
+		objs = append(objs, &plans.Items[i])
+	}
+	for _, pl := range objs {
+		if !pl.GetDeletionTimestamp().IsZero() {
+			// The finalizer is deleting the synced secrets of the Plan.
+			continue
+		}
+		if !inScope(pl) {
+			continue
+		}
+		if !f(pl) {
+			break
+		}
+	}
+	return nil
 }
 
-func (s *cache) Delete(name string) {
-	s.sm.Delete(name)
+// inScope reports whether the plan syncs secrets only within its scope.
+// A Plan may only collect or merge secrets into its own namespace, while a ClusterPlan may sync across namespaces.
+// The validating webhook rejects Plans out of scope, but they are ignored here in case the webhook is not installed.
+func inScope(plan riggerv1beta1.PlanObject) bool {
+	if plan.GetNamespace() == "" {
+		return true
+	}
+	spec := plan.GetSpec()
+	return spec.GetMode() != riggerv1beta1.PlanModeDistribute && spec.SyncDestNamespace == plan.GetNamespace()
 }
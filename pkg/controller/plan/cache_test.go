@@ -0,0 +1,38 @@
+package plan
+
+import (
+	"sort"
+	"testing"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCacheRange(t *testing.T) {
+	now := metav1.Now()
+	spec := riggerv1beta1.PlanSpec{SyncTargetSecretName: "token", SyncDestNamespace: "team-a"}
+	// The plans have never been reconciled, so they have neither finalizers nor status.
+	c := &cache{}
+	c.setReader(fake.NewFakeClient(
+		&riggerv1beta1.ClusterPlan{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}, Spec: spec},
+		&riggerv1beta1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "plan"}, Spec: spec},
+		&riggerv1beta1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "out-of-scope"}, Spec: spec},
+		&riggerv1beta1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "deleting", DeletionTimestamp: &now}, Spec: spec},
+	))
+
+	got := []string{}
+	if err := c.Range(func(pl riggerv1beta1.PlanObject) bool {
+		got = append(got, types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}.String())
+		return true
+	}); err != nil {
+		t.Fatalf("Range returned error: %v", err)
+	}
+	sort.Strings(got)
+	want := []string{"/cluster", "team-a/plan"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Range = %v, want %v", got, want)
+	}
+}
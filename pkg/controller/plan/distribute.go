@@ -0,0 +1,152 @@
+package plan
+
+import (
+	"fmt"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	"github.com/wantedly/rigger/pkg/clientset"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+	"github.com/wantedly/rigger/pkg/util"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DistributeAllNamespaceSecrets syncs the source secret to the namespaces matching filter on behalf of the plan.
+// A namespace failing to sync does not stop syncing the others, and is returned in failures.
+func DistributeAllNamespaceSecrets(pl riggerv1beta1.PlanObject, source *corev1.SecretReference, opts riggertypes.DstSecretOptions, filter util.NamespaceFilter) (failures []riggerv1beta1.SyncFailure, err error) {
+	srcSecret, err := clientset.Objects(opts.GroupVersionKind).Get(source.Namespace, source.Name)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to get secret [namespace:%s,name:%s]", source.Namespace, source.Name)
+	}
+	namespaces, err := clientset.GetNamespaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get namespaces")
+	}
+	for i := range namespaces {
+		if _, err := DistributeNamespaceSecret(pl, srcSecret, opts, filter, &namespaces[i]); err != nil {
+			log.Error(err, fmt.Sprintf("failed to distribute secret [namespace:%s]", namespaces[i].Name))
+			RecordSyncFailed(pl, namespaces[i].Name, err)
+			failures = append(failures, riggerv1beta1.SyncFailure{Namespace: namespaces[i].Name, Message: err.Error()})
+		}
+	}
+	return failures, nil
+}
+
+// DistributeNamespaceSecret syncs srcSecret to dstNamespace on behalf of the plan if filter matches dstNamespace.
+// The synced secret has the same name as srcSecret. written reports whether the synced secret has been created or updated.
+func DistributeNamespaceSecret(pl riggerv1beta1.PlanObject, srcSecret *corev1.Secret, opts riggertypes.DstSecretOptions, filter util.NamespaceFilter, dstNamespace *corev1.Namespace) (written bool, err error) {
+	plan := planKey(pl)
+	if dstNamespace.Name == srcSecret.Namespace {
+		return false, nil
+	}
+	if riggertypes.IsDstSecret(srcSecret) {
+		// Copies never chain, see detectLoop.
+		return false, nil
+	}
+	matched, err := filter.Matches(dstNamespace)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to match namespace [namespace:%s]", dstNamespace.Name)
+	}
+	if !matched {
+		return false, nil
+	}
+	dstSecret := riggertypes.NewDstSecret(plan, dstNamespace.Name, riggertypes.DstSecretName(srcSecret.Name), srcSecret, opts)
+	created, err := clientset.Objects(opts.GroupVersionKind).Create(dstSecret.Namespace, dstSecret)
+	if !apierrors.IsAlreadyExists(err) {
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to create secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+		}
+		log.Info(fmt.Sprintf("succeeded to create secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+		RecordSecretCreated(pl, created)
+		return true, nil
+	}
+	existing, err := clientset.Objects(opts.GroupVersionKind).Get(dstSecret.Namespace, dstSecret.Name)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+	}
+	// Never overwrite a secret which the namespace owns by itself.
+	if p, ok := riggertypes.GetDstSecretPlan(existing); !ok || p != plan {
+		log.Info(fmt.Sprintf("skipped to overwrite secret not synced by the plan [namespace:%s,name:%s,plan:%s]", dstSecret.Namespace, dstSecret.Name, plan))
+		return false, nil
+	}
+	if riggertypes.IsDstSecretUpToDate(existing, dstSecret) {
+		return false, nil
+	}
+	updated, err := clientset.Objects(opts.GroupVersionKind).Update(dstSecret.Namespace, dstSecret)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to update secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+	}
+	log.Info(fmt.Sprintf("succeeded to update secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+	RecordSecretUpdated(pl, updated)
+	return true, nil
+}
+
+// PruneDistributedSecrets deletes the objects of gvk which the plan synced to the namespaces not matching filter
+// or from an object other than source.
+func PruneDistributedSecrets(pl riggerv1beta1.PlanObject, gvk schema.GroupVersionKind, source *corev1.SecretReference, filter util.NamespaceFilter) error {
+	plan := planKey(pl)
+	namespaces, err := clientset.GetNamespaces()
+	if err != nil {
+		return errors.Wrap(err, "failed to get namespaces")
+	}
+	dstNamespaces := map[string]bool{}
+	for i := range namespaces {
+		matched, err := filter.Matches(&namespaces[i])
+		if err != nil {
+			return errors.Wrapf(err, "failed to match namespace [namespace:%s]", namespaces[i].Name)
+		}
+		dstNamespaces[namespaces[i].Name] = matched
+	}
+	dstSecrets, err := listSyncedSecrets(plan, gvk, metav1.NamespaceAll, riggertypes.NewPlanDstSecretLabels(plan))
+	if err != nil {
+		return err
+	}
+	for _, dstSecret := range dstSecrets {
+		srcNamespace, srcName, _ := riggertypes.GetSrcSecret(&dstSecret)
+		if dstNamespaces[dstSecret.Namespace] && dstSecret.Namespace != source.Namespace &&
+			srcNamespace == source.Namespace && srcName == source.Name && dstSecret.Name == source.Name {
+			continue
+		}
+		err := clientset.Objects(gvk).Delete(dstSecret.Namespace, dstSecret.Name, nil)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to delete secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+		}
+		log.Info(fmt.Sprintf("succeeded to delete secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+		RecordSecretPruned(pl, dstSecret.Namespace, dstSecret.Name)
+	}
+	return nil
+}
+
+// CountDistributedSecrets returns the number of the objects of gvk which the plan synced to any namespace.
+func CountDistributedSecrets(plan types.NamespacedName, gvk schema.GroupVersionKind) (int, error) {
+	return countSyncedSecrets(metav1.NamespaceAll, plan, gvk)
+}
+
+// DeleteDistributedSecrets deletes all the objects of gvk which the plan synced to any namespace.
+func DeleteDistributedSecrets(pl riggerv1beta1.PlanObject, gvk schema.GroupVersionKind) error {
+	plan := planKey(pl)
+	dstSecrets, err := listSyncedSecrets(plan, gvk, metav1.NamespaceAll, riggertypes.NewPlanDstSecretLabels(plan))
+	if err != nil {
+		return err
+	}
+	for _, dstSecret := range dstSecrets {
+		err := clientset.Objects(gvk).Delete(dstSecret.Namespace, dstSecret.Name, nil)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to delete secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+		}
+		log.Info(fmt.Sprintf("succeeded to delete secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+		RecordSecretPruned(pl, dstSecret.Namespace, dstSecret.Name)
+	}
+	return nil
+}
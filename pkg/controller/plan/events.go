@@ -0,0 +1,87 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the events recorded on Plans and synced Secrets.
+const (
+	ReasonSecretSynced = "SecretSynced"
+	ReasonSecretPruned = "SecretPruned"
+	ReasonSyncFailed   = "SyncFailed"
+	ReasonLoopDetected = "LoopDetected"
+	ReasonKeysSkipped  = "KeysSkipped"
+)
+
+// recorder records the events of syncing secrets. It is set by Add.
+var recorder record.EventRecorder
+
+func setRecorder(r record.EventRecorder) {
+	recorder = r
+}
+
+// RecordSecretCreated records that the plan created dstSecret on both the plan and dstSecret.
+func RecordSecretCreated(pl riggerv1beta1.PlanObject, dstSecret *corev1.Secret) {
+	countSecretOperation(planKey(pl), OperationCreated)
+	recordSecretSynced(pl, dstSecret)
+}
+
+// RecordSecretUpdated records that the plan updated dstSecret on both the plan and dstSecret.
+func RecordSecretUpdated(pl riggerv1beta1.PlanObject, dstSecret *corev1.Secret) {
+	countSecretOperation(planKey(pl), OperationUpdated)
+	recordSecretSynced(pl, dstSecret)
+}
+
+func recordSecretSynced(pl riggerv1beta1.PlanObject, dstSecret *corev1.Secret) {
+	srcNamespace, srcName, ok := riggertypes.GetSrcSecret(dstSecret)
+	if !ok {
+		// A merged secret has many sources.
+		recordPlanEvent(pl, corev1.EventTypeNormal, ReasonSecretSynced, fmt.Sprintf("Merged secrets into %s/%s", dstSecret.Namespace, dstSecret.Name))
+		if recorder != nil {
+			recorder.Event(dstSecret, corev1.EventTypeNormal, ReasonSecretSynced, fmt.Sprintf("Merged by plan %s", planKey(pl)))
+		}
+		return
+	}
+	src := srcNamespace + "/" + srcName
+	recordPlanEvent(pl, corev1.EventTypeNormal, ReasonSecretSynced, fmt.Sprintf("Synced secret %s to %s/%s", src, dstSecret.Namespace, dstSecret.Name))
+	if recorder != nil {
+		recorder.Event(dstSecret, corev1.EventTypeNormal, ReasonSecretSynced, fmt.Sprintf("Synced from secret %s by plan %s", src, planKey(pl)))
+	}
+}
+
+// RecordSecretPruned records that the plan deleted the synced secret dstName from dstNamespace on the plan.
+func RecordSecretPruned(pl riggerv1beta1.PlanObject, dstNamespace, dstName string) {
+	countSecretOperation(planKey(pl), OperationDeleted)
+	recordPlanEvent(pl, corev1.EventTypeNormal, ReasonSecretPruned, fmt.Sprintf("Deleted synced secret %s from namespace %s", dstName, dstNamespace))
+}
+
+// RecordSyncFailed records that the plan failed to sync the secrets of namespace on the plan.
+func RecordSyncFailed(pl riggerv1beta1.PlanObject, namespace string, err error) {
+	countSecretOperation(planKey(pl), OperationFailed)
+	recordPlanEvent(pl, corev1.EventTypeWarning, ReasonSyncFailed, fmt.Sprintf("Failed to sync secrets of namespace %s: %v", namespace, err))
+}
+
+// RecordKeysSkipped records the keys which the plan skipped to merge into dstSecret on the plan.
+func RecordKeysSkipped(pl riggerv1beta1.PlanObject, dstSecret *corev1.Secret, skipped []string) {
+	recordPlanEvent(pl, corev1.EventTypeWarning, ReasonKeysSkipped, fmt.Sprintf("Skipped to merge %s into %s/%s, since they are invalid or given by another secret", strings.Join(skipped, ", "), dstSecret.Namespace, dstSecret.Name))
+}
+
+func recordPlanEvent(pl riggerv1beta1.PlanObject, eventtype, reason, message string) {
+	if recorder == nil {
+		return
+	}
+	recorder.Event(pl, eventtype, reason, message)
+}
+
+// planKey returns the key of the plan, whose namespace is empty for a ClusterPlan.
+func planKey(pl riggerv1beta1.PlanObject) types.NamespacedName {
+	return types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}
+}
@@ -0,0 +1,67 @@
+package plan
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRecordEvents(t *testing.T) {
+	defer setRecorder(recorder)
+	pl := &riggerv1beta1.ClusterPlan{ObjectMeta: metav1.ObjectMeta{Name: "plan"}}
+	srcSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "token"}}
+	dstSecret := riggertypes.NewDstSecret(types.NamespacedName{Name: "plan"}, "dst", riggertypes.NewDstSecretName("team-a", "token"), srcSecret, riggertypes.DstSecretOptions{})
+	merged := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "dst", Name: "merged"}}
+
+	cases := []struct {
+		name   string
+		record func()
+		// want holds the prefixes of the events recorded, in order.
+		want []string
+	}{
+		{name: "created", record: func() { RecordSecretCreated(pl, dstSecret) }, want: []string{
+			"Normal SecretSynced Synced secret team-a/token to dst/team-a.token",
+			"Normal SecretSynced Synced from secret team-a/token by plan /plan",
+		}},
+		{name: "merged", record: func() { RecordSecretUpdated(pl, merged) }, want: []string{
+			"Normal SecretSynced Merged secrets into dst/merged",
+			"Normal SecretSynced Merged by plan /plan",
+		}},
+		{name: "pruned", record: func() { RecordSecretPruned(pl, "dst", "team-a.token") }, want: []string{
+			"Normal SecretPruned Deleted synced secret team-a.token from namespace dst",
+		}},
+		{name: "failed", record: func() { RecordSyncFailed(pl, "team-a", errors.New("forbidden")) }, want: []string{
+			"Warning SyncFailed Failed to sync secrets of namespace team-a: forbidden",
+		}},
+		{name: "keys skipped", record: func() { RecordKeysSkipped(pl, merged, []string{"team-a/token: key"}) }, want: []string{
+			"Warning KeysSkipped Skipped to merge team-a/token: key into dst/merged",
+		}},
+	}
+	for _, c := range cases {
+		fake := record.NewFakeRecorder(len(c.want) + 1)
+		setRecorder(fake)
+		c.record()
+		close(fake.Events)
+		got := []string{}
+		for e := range fake.Events {
+			got = append(got, e)
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%s: recorded %q, want %d events", c.name, got, len(c.want))
+			continue
+		}
+		for i := range got {
+			if !strings.HasPrefix(got[i], c.want[i]) {
+				t.Errorf("%s: event %q, want prefix %q", c.name, got[i], c.want[i])
+			}
+		}
+	}
+}
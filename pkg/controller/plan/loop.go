@@ -0,0 +1,102 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+	"github.com/wantedly/rigger/pkg/util"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// detectLoop returns why the spec of the plan would sync secrets recursively, or "" if it would not.
+// The controllers skip such sources anyway, see util.IsSyncSource. The objects are read through r,
+// the cache of the manager, since it runs on every status update.
+func detectLoop(r client.Reader, plan riggerv1beta1.PlanObject) (string, error) {
+	spec := plan.GetSpec()
+	gvk := spec.GetGroupVersionKind()
+	if spec.GetMode() == riggerv1beta1.PlanModeDistribute {
+		if spec.Source == nil {
+			return "", nil
+		}
+		key := types.NamespacedName{Namespace: spec.Source.Namespace, Name: spec.Source.Name}
+		srcSecret, notFound, err := util.ReconcilesFetchObject(r, context.TODO(), gvk, key)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get secret [namespace:%s,name:%s]", key.Namespace, key.Name)
+		}
+		if !notFound && riggertypes.IsDstSecret(srcSecret) {
+			return fmt.Sprintf("source secret %s/%s has been synced by rigger and is not distributed", srcSecret.Namespace, srcSecret.Name), nil
+		}
+		return "", nil
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(context.TODO(), &client.ListOptions{}, namespaces); err != nil {
+		return "", errors.Wrap(err, "failed to list namespaces")
+	}
+	filter := util.NewNamespaceFilter(plan)
+	srcNamespaces := map[string]bool{}
+	for i := range namespaces.Items {
+		matched, err := filter.Matches(&namespaces.Items[i])
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to match namespace [namespace:%s]", namespaces.Items[i].Name)
+		}
+		srcNamespaces[namespaces.Items[i].Name] = matched
+	}
+	targets := spec.GetSyncTargets()
+
+	// A ClusterPlan skips the target secrets in its destination, which it would copy into their own namespace.
+	// A Plan syncs within its own namespace, where the destination is its only source.
+	if plan.GetNamespace() == "" && srcNamespaces[spec.SyncDestNamespace] {
+		secrets, err := util.ReconcilesListObjects(r, context.TODO(), gvk, (&client.ListOptions{}).InNamespace(spec.SyncDestNamespace))
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to list secrets [namespace:%s]", spec.SyncDestNamespace)
+		}
+		for i := range secrets {
+			if riggertypes.IsDstSecret(&secrets[i]) {
+				continue
+			}
+			target, err := util.FindSyncTarget(targets, &secrets[i])
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to match secret [namespace:%s,name:%s]", secrets[i].Namespace, secrets[i].Name)
+			}
+			if target != nil {
+				return fmt.Sprintf("targets select secret %s/%s in syncDestNamespace, which is not synced into its own namespace", secrets[i].Namespace, secrets[i].Name), nil
+			}
+		}
+	}
+
+	// Look for the secrets synced by other plans which the targets would sync again.
+	// The copies of the plan itself match its targets whenever they keep the labels of their sources,
+	// which never chains either.
+	planKey := types.NamespacedName{Namespace: plan.GetNamespace(), Name: plan.GetName()}
+	opts := (&client.ListOptions{}).InNamespace(plan.GetNamespace()).MatchingLabels(map[string]string{
+		riggertypes.DstSecretLabelCreatedByRiggerKey: riggertypes.DstSecretLabelCreatedByRiggerValue,
+	})
+	dstSecrets, err := util.ReconcilesListObjects(r, context.TODO(), gvk, opts)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list secrets synced by rigger")
+	}
+	for i := range dstSecrets {
+		if !srcNamespaces[dstSecrets[i].Namespace] {
+			continue
+		}
+		by, _ := riggertypes.GetDstSecretPlan(&dstSecrets[i])
+		if by == planKey {
+			continue
+		}
+		target, err := util.FindSyncTarget(targets, &dstSecrets[i])
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to match secret [namespace:%s,name:%s]", dstSecrets[i].Namespace, dstSecrets[i].Name)
+		}
+		if target != nil {
+			return fmt.Sprintf("targets select secret %s/%s synced by plan %s, which is not synced again", dstSecrets[i].Namespace, dstSecrets[i].Name, by), nil
+		}
+	}
+	return "", nil
+}
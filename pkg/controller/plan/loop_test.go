@@ -0,0 +1,54 @@
+package plan
+
+import (
+	"testing"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDetectLoop(t *testing.T) {
+	namespace := func(name string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	secret := func(namespace, name string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	spec := riggerv1beta1.PlanSpec{SyncTargetSecretName: "token", SyncDestNamespace: "dst"}
+	clusterPlan := &riggerv1beta1.ClusterPlan{ObjectMeta: metav1.ObjectMeta{Name: "plan"}, Spec: spec}
+	plan := &riggerv1beta1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: "dst", Name: "plan"}, Spec: spec}
+	// A copy named after the target, as if by another plan with destinationNameTemplate.
+	otherCopy := riggertypes.NewDstSecret(types.NamespacedName{Name: "other"}, "src", "token", secret("team-a", "token"), riggertypes.NewDstSecretOptions(&spec))
+	ownCopy := riggertypes.NewDstSecret(types.NamespacedName{Name: "plan"}, "src", "token", secret("team-a", "token"), riggertypes.NewDstSecretOptions(&spec))
+
+	cases := []struct {
+		name     string
+		plan     riggerv1beta1.PlanObject
+		objs     []runtime.Object
+		wantLoop bool
+	}{
+		{name: "no target in destination", plan: clusterPlan, objs: []runtime.Object{namespace("src"), namespace("dst"), secret("src", "token")}},
+		{name: "target in destination", plan: clusterPlan, objs: []runtime.Object{namespace("src"), namespace("dst"), secret("dst", "token")}, wantLoop: true},
+		{name: "target in ignored destination", plan: &riggerv1beta1.ClusterPlan{
+			ObjectMeta: metav1.ObjectMeta{Name: "plan"},
+			Spec:       riggerv1beta1.PlanSpec{SyncTargetSecretName: "token", SyncDestNamespace: "dst", IgnoreNamespaces: []string{"dst"}},
+		}, objs: []runtime.Object{namespace("dst"), secret("dst", "token")}},
+		{name: "target in destination of Plan", plan: plan, objs: []runtime.Object{namespace("dst"), secret("dst", "token")}},
+		{name: "copy of other plan", plan: clusterPlan, objs: []runtime.Object{namespace("src"), namespace("dst"), otherCopy}, wantLoop: true},
+		{name: "copy of the plan itself", plan: clusterPlan, objs: []runtime.Object{namespace("src"), namespace("dst"), ownCopy}},
+	}
+	for _, c := range cases {
+		loop, err := detectLoop(fake.NewFakeClient(c.objs...), c.plan)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		} else if (loop != "") != c.wantLoop {
+			t.Errorf("%s: detectLoop = %q, want loop %v", c.name, loop, c.wantLoop)
+		}
+	}
+}
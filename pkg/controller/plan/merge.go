@@ -0,0 +1,197 @@
+package plan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	"github.com/wantedly/rigger/pkg/clientset"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+	"github.com/wantedly/rigger/pkg/util"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MergeSecrets rebuilds the secret into which the plan in Merge mode merges the target secrets of the selected namespaces,
+// reading the namespaces and the sources through r, the cache of the manager.
+// The keys skipped for their collisions or invalid names are reported in an event when the secret is written,
+// but not as failures, since merging the secret again never changes them. written reports whether the secret has been created or updated.
+func MergeSecrets(r client.Reader, pl riggerv1beta1.PlanObject) (written bool, err error) {
+	plan := planKey(pl)
+	spec := pl.GetSpec()
+	destNamespace := spec.SyncDestNamespace
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(context.TODO(), &client.ListOptions{}, namespaces); err != nil {
+		return false, errors.Wrap(err, "failed to list namespaces")
+	}
+	filter := util.NewNamespaceFilter(pl)
+	srcNamespaces := map[string]bool{}
+	for i := range namespaces.Items {
+		matched, err := filter.Matches(&namespaces.Items[i])
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to match namespace [namespace:%s]", namespaces.Items[i].Name)
+		}
+		srcNamespaces[namespaces.Items[i].Name] = matched
+	}
+	secrets, err := util.ReconcilesListObjects(r, context.TODO(), spec.GetGroupVersionKind(), (&client.ListOptions{}).InNamespace(pl.GetNamespace()))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list secrets")
+	}
+	targets := spec.GetSyncTargets()
+	srcSecrets := []*corev1.Secret{}
+	for i := range secrets {
+		if !srcNamespaces[secrets[i].Namespace] || !util.IsSyncSource(plan, destNamespace, &secrets[i]) {
+			continue
+		}
+		target, err := util.FindSyncTarget(targets, &secrets[i])
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to match secret [namespace:%s,name:%s]", secrets[i].Namespace, secrets[i].Name)
+		}
+		if target != nil {
+			srcSecrets = append(srcSecrets, &secrets[i])
+		}
+	}
+	dstSecret, skipped, err := riggertypes.NewMergedSecret(plan, destNamespace, util.MergedSecretName(pl), srcSecrets, riggertypes.NewDstSecretOptions(spec))
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to merge secrets into [namespace:%s,name:%s]", destNamespace, util.MergedSecretName(pl))
+	}
+	written, err = writeMergedSecret(pl, spec.GetGroupVersionKind(), dstSecret)
+	if err != nil {
+		return false, err
+	}
+	if written && len(skipped) > 0 {
+		log.Info(fmt.Sprintf("skipped to merge keys [namespace:%s,name:%s,plan:%s]: %s", destNamespace, dstSecret.Name, plan, strings.Join(skipped, "; ")))
+		RecordKeysSkipped(pl, dstSecret, skipped)
+	}
+	return written, nil
+}
+
+// writeMergedSecret creates or updates dstSecret, the secret merged by the plan, and reports whether it has been written.
+func writeMergedSecret(pl riggerv1beta1.PlanObject, gvk schema.GroupVersionKind, dstSecret *corev1.Secret) (written bool, err error) {
+	plan := planKey(pl)
+	created, err := clientset.Objects(gvk).Create(dstSecret.Namespace, dstSecret)
+	if !apierrors.IsAlreadyExists(err) {
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to create secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+		}
+		log.Info(fmt.Sprintf("succeeded to create secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+		RecordSecretCreated(pl, created)
+		return true, nil
+	}
+	existing, err := clientset.Objects(gvk).Get(dstSecret.Namespace, dstSecret.Name)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+	}
+	// Never overwrite a secret which the namespace owns by itself.
+	if p, ok := riggertypes.GetDstSecretPlan(existing); !ok || p != plan {
+		return false, fmt.Errorf("secret [namespace:%s,name:%s] exists and is not merged by the plan", dstSecret.Namespace, dstSecret.Name)
+	}
+	if riggertypes.IsDstSecretUpToDate(existing, dstSecret) {
+		return false, nil
+	}
+	updated, err := clientset.Objects(gvk).Update(dstSecret.Namespace, dstSecret)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to update secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+	}
+	log.Info(fmt.Sprintf("succeeded to update secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+	RecordSecretUpdated(pl, updated)
+	return true, nil
+}
+
+// UpdateRenderedCondition records on the status of the plan in Merge mode whether its template rendered
+// the merged secret, given the result of MergeSecrets. The status is written only if the condition changes.
+func UpdateRenderedCondition(c client.StatusClient, pl riggerv1beta1.PlanObject, mergeErr error) error {
+	if !setRenderedCondition(pl, mergeErr) {
+		return nil
+	}
+	if err := util.ReconcilesUpdatePlanStatus(c, context.TODO(), pl); err != nil {
+		return errors.Wrapf(err, "failed to update plan status [namespace:%s,name:%s]", pl.GetNamespace(), pl.GetName())
+	}
+	return nil
+}
+
+// setRenderedCondition sets the PlanRendered condition of the plan given the result of MergeSecrets,
+// and reports whether the condition has changed. Errors other than rendering leave the condition as it is.
+func setRenderedCondition(pl riggerv1beta1.PlanObject, mergeErr error) bool {
+	status := pl.GetStatus()
+	var before riggerv1beta1.PlanCondition
+	if c := status.GetCondition(riggerv1beta1.PlanRendered); c != nil {
+		before = *c
+	}
+	_, isTemplateErr := errors.Cause(mergeErr).(*riggertypes.TemplateError)
+	switch {
+	case pl.GetSpec().Template == nil:
+		if before.Type == "" {
+			return false
+		}
+		conditions := []riggerv1beta1.PlanCondition{}
+		for _, c := range status.Conditions {
+			if c.Type != riggerv1beta1.PlanRendered {
+				conditions = append(conditions, c)
+			}
+		}
+		status.Conditions = conditions
+		return true
+	case isTemplateErr:
+		status.SetCondition(riggerv1beta1.PlanRendered, corev1.ConditionFalse, "RenderFailed", mergeErr.Error())
+	case mergeErr == nil:
+		status.SetCondition(riggerv1beta1.PlanRendered, corev1.ConditionTrue, "RenderSucceeded", "")
+	default:
+		return false
+	}
+	return *status.GetCondition(riggerv1beta1.PlanRendered) != before
+}
+
+// GetMergedSources returns the sources of the secret which the plan in Merge mode merged, reading the secret through r.
+func GetMergedSources(r client.Reader, pl riggerv1beta1.PlanObject) (riggertypes.MergedSources, error) {
+	key := types.NamespacedName{Namespace: pl.GetSpec().SyncDestNamespace, Name: util.MergedSecretName(pl)}
+	mergedSecret, notFound, err := util.ReconcilesFetchObject(r, context.TODO(), pl.GetSpec().GetGroupVersionKind(), key)
+	if err != nil {
+		return riggertypes.MergedSources{}, errors.Wrapf(err, "failed to get secret [namespace:%s,name:%s]", key.Namespace, key.Name)
+	}
+	if notFound {
+		return riggertypes.GetMergedSources(&corev1.Secret{}), nil
+	}
+	return riggertypes.GetMergedSources(mergedSecret), nil
+}
+
+// PruneMergedSecrets deletes the objects of gvk which the plan merged other than the dstName object of destNamespace.
+func PruneMergedSecrets(pl riggerv1beta1.PlanObject, gvk schema.GroupVersionKind, destNamespace, dstName string) error {
+	plan := planKey(pl)
+	dstSecrets, err := listSyncedSecrets(plan, gvk, metav1.NamespaceAll, riggertypes.NewPlanDstSecretLabels(plan))
+	if err != nil {
+		return err
+	}
+	for _, dstSecret := range dstSecrets {
+		if dstSecret.Namespace == destNamespace && dstSecret.Name == dstName {
+			continue
+		}
+		err := clientset.Objects(gvk).Delete(dstSecret.Namespace, dstSecret.Name, nil)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to delete secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+		}
+		log.Info(fmt.Sprintf("succeeded to delete secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+		RecordSecretPruned(pl, dstSecret.Namespace, dstSecret.Name)
+	}
+	return nil
+}
+
+// CountMergedSecrets returns the number of the objects of gvk which the plan merged into any namespace.
+func CountMergedSecrets(plan types.NamespacedName, gvk schema.GroupVersionKind) (int, error) {
+	return countSyncedSecrets(metav1.NamespaceAll, plan, gvk)
+}
+
+// DeleteMergedSecrets deletes all the objects of gvk which the plan merged into any namespace.
+func DeleteMergedSecrets(pl riggerv1beta1.PlanObject, gvk schema.GroupVersionKind) error {
+	// The merged secrets carry the same labels as the distributed ones.
+	return DeleteDistributedSecrets(pl, gvk)
+}
@@ -0,0 +1,104 @@
+package plan
+
+import (
+	"sync"
+	"time"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Operations on the synced secrets counted by the rigger_secret_operations_total metric.
+const (
+	OperationCreated = "created"
+	OperationUpdated = "updated"
+	OperationDeleted = "deleted"
+	OperationFailed  = "failed"
+)
+
+var (
+	secretOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rigger_secret_operations_total",
+		Help: "Total number of operations on the synced secrets per plan and operation.",
+	}, []string{"plan", "operation"})
+
+	syncLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rigger_sync_latency_seconds",
+		Help:    "Seconds from a change of a source secret to writing the synced secret.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	})
+
+	syncedSecrets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rigger_plan_synced_secrets",
+		Help: "Number of the secrets synced by the plan as of its last sync.",
+	}, []string{"plan"})
+
+	lastSyncTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rigger_plan_last_sync_timestamp_seconds",
+		Help: "Unix time of the last sync of the plan.",
+	}, []string{"plan"})
+
+	cachedPlans = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rigger_cache_plans",
+		Help: "Number of the plans and clusterplans in the cache which the secret controllers follow.",
+	})
+
+	// followedPlans is the set of the plans counted by cachedPlans, kept by the plan-controller
+	// instead of listing the plans on every scrape.
+	followedPlansMu sync.Mutex
+	followedPlans   = map[types.NamespacedName]bool{}
+)
+
+func init() {
+	metrics.Registry.MustRegister(secretOperations, syncLatency, syncedSecrets, lastSyncTime, cachedPlans)
+}
+
+// planLabel returns the value of the plan label, which is "namespace/name" for a Plan and "name" for a ClusterPlan.
+func planLabel(plan types.NamespacedName) string {
+	if plan.Namespace == "" {
+		return plan.Name
+	}
+	return plan.String()
+}
+
+func countSecretOperation(plan types.NamespacedName, operation string) {
+	secretOperations.WithLabelValues(planLabel(plan), operation).Inc()
+}
+
+// ObserveSyncLatency records the time since a source secret changed, once the synced secret has been written.
+func ObserveSyncLatency(changed time.Time) {
+	syncLatency.Observe(time.Since(changed).Seconds())
+}
+
+// setPlanMetrics exports the result of the last sync recorded on the status of the plan.
+func setPlanMetrics(plan types.NamespacedName, status *riggerv1beta1.PlanStatus) {
+	syncedSecrets.WithLabelValues(planLabel(plan)).Set(float64(status.SyncedSecrets))
+	if status.LastSyncTime != nil {
+		lastSyncTime.WithLabelValues(planLabel(plan)).Set(float64(status.LastSyncTime.Unix()))
+	}
+}
+
+// deletePlanMetrics stops exporting the metrics of the deleted plan.
+func deletePlanMetrics(plan types.NamespacedName) {
+	label := planLabel(plan)
+	syncedSecrets.DeleteLabelValues(label)
+	lastSyncTime.DeleteLabelValues(label)
+	for _, operation := range []string{OperationCreated, OperationUpdated, OperationDeleted, OperationFailed} {
+		secretOperations.DeleteLabelValues(label, operation)
+	}
+}
+
+// setPlanFollowed records whether the secret controllers follow the plan, that is, the Cache ranges over it.
+func setPlanFollowed(plan types.NamespacedName, followed bool) {
+	followedPlansMu.Lock()
+	defer followedPlansMu.Unlock()
+	if followed {
+		followedPlans[plan] = true
+	} else {
+		delete(followedPlans, plan)
+	}
+	cachedPlans.Set(float64(len(followedPlans)))
+}
@@ -0,0 +1,70 @@
+package plan
+
+import (
+	"testing"
+	"time"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// metricValue returns the value of the counter, gauge or histogram sum of m, and the histogram sample count.
+func metricValue(t *testing.T, m prometheus.Metric) (float64, uint64) {
+	out := &dto.Metric{}
+	if err := m.Write(out); err != nil {
+		t.Fatalf("failed to write metric: %v", err)
+	}
+	switch {
+	case out.Counter != nil:
+		return out.Counter.GetValue(), 0
+	case out.Gauge != nil:
+		return out.Gauge.GetValue(), 0
+	default:
+		return out.Histogram.GetSampleSum(), out.Histogram.GetSampleCount()
+	}
+}
+
+func TestPlanMetrics(t *testing.T) {
+	plan := types.NamespacedName{Namespace: "default", Name: "metrics"}
+	now := metav1.Unix(1600000000, 0)
+	setPlanMetrics(plan, &riggerv1beta1.PlanStatus{SyncedSecrets: 3, LastSyncTime: &now})
+	countSecretOperation(plan, OperationCreated)
+	countSecretOperation(plan, OperationCreated)
+
+	for name, c := range map[string]struct {
+		metric prometheus.Metric
+		want   float64
+	}{
+		"synced secrets":    {metric: syncedSecrets.WithLabelValues("default/metrics"), want: 3},
+		"last sync time":    {metric: lastSyncTime.WithLabelValues("default/metrics"), want: 1600000000},
+		"created secrets":   {metric: secretOperations.WithLabelValues("default/metrics", OperationCreated), want: 2},
+		"ClusterPlan label": {metric: secretOperations.WithLabelValues("metrics", OperationCreated), want: 0},
+	} {
+		if got, _ := metricValue(t, c.metric); got != c.want {
+			t.Errorf("%s = %v, want %v", name, got, c.want)
+		}
+	}
+
+	// The deleted plan is no longer exported, so its metrics start over.
+	deletePlanMetrics(plan)
+	if got, _ := metricValue(t, secretOperations.WithLabelValues("default/metrics", OperationCreated)); got != 0 {
+		t.Errorf("created secrets after deletion = %v, want 0", got)
+	}
+	deletePlanMetrics(plan)
+}
+
+func TestObserveSyncLatency(t *testing.T) {
+	sumBefore, before := metricValue(t, syncLatency)
+	ObserveSyncLatency(time.Now().Add(-2 * time.Second))
+	sum, count := metricValue(t, syncLatency)
+	if count != before+1 {
+		t.Errorf("sample count = %d, want %d", count, before+1)
+	}
+	if latency := sum - sumBefore; latency < 2 || latency > 3 {
+		t.Errorf("observed latency = %v, want about 2 seconds since the change", latency)
+	}
+}
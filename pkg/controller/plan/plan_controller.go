@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"time"
 
 	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
 	"github.com/wantedly/rigger/pkg/clientset"
@@ -12,7 +13,6 @@ import (
 
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -26,9 +26,19 @@ import (
 
 var log = logf.Log.WithName("plan-controller")
 
+// planFinalizerName is the finalizer which deletes the secrets synced by a Plan on its deletion.
+const planFinalizerName = "finalizer.rigger.k8s.wantedly.com"
+
+// degradedRequeueAfter is the interval to retry the namespaces which failed to sync.
+const degradedRequeueAfter = time.Minute
+
 // Add creates a new Plan Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
+	// The src-secret-controller and dst-secret-controller read Plans through the Cache.
+	Cache.setReader(mgr.GetCache())
+	// They also record events through the recorder of this package.
+	setRecorder(mgr.GetRecorder("rigger"))
 	return add(mgr, newReconciler(mgr))
 }
 
@@ -51,6 +61,13 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to ClusterPlan
+	// The requests of ClusterPlans have no namespace, which tells them from the requests of Plans.
+	err = c.Watch(&source.Kind{Type: &riggerv1beta1.ClusterPlan{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
 	// Watch for changes to Secret
 	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
@@ -71,117 +88,453 @@ type ReconcilePlan struct {
 	scheme *runtime.Scheme
 }
 
-// Reconcile reads that state of the cluster for a Plan object and makes changes based on the state read
+// Reconcile reads that state of the cluster for a Plan or ClusterPlan object and makes changes based on the state read
 // and what is in the Plan.Spec
-// Automatically generate RBAC rules to allow the Controller to read and write Plans and Namespaces
+// Automatically generate RBAC rules to allow the Controller to read and write Plans, ClusterPlans and Namespaces
 // +kubebuilder:rbac:groups=rigger.k8s.wantedly.com,resources=plans,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=rigger.k8s.wantedly.com,resources=plans/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=rigger.k8s.wantedly.com,resources=clusterplans,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rigger.k8s.wantedly.com,resources=clusterplans/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=cores,resources=namespaces,verbs=get;list
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 func (r *ReconcilePlan) Reconcile(request reconcile.Request) (reconcile.Result, error) {
 	// Fetch the Plan instance
 	plan, planDeleted, err := util.ReconcilesFetchPlan(r, context.TODO(), request.NamespacedName)
-	// Plan Deleted
 	if planDeleted {
-		deletedPlan, found := Cache.Load(request.NamespacedName.Name)
-		if !found {
-			return reconcile.Result{}, fmt.Errorf("failed to delete secret collection of deleted plan because deleted plan name '%s' is not found in cache", request.NamespacedName.Name)
-		}
-		log.Info(fmt.Sprintf("plan deleted [namespace:%s,name:%s]", request.NamespacedName.Namespace, request.NamespacedName.Name))
-		Cache.Delete(request.NamespacedName.Name)
-		dstNamespace := deletedPlan.Spec.SyncDestNamespace
-		labelSelector := riggertypes.DstSecretLabelCreatedByRiggerKey + "=" + riggertypes.DstSecretLabelCreatedByRiggerValue
-		err := clientset.DeleteSecretCollection(dstNamespace, nil, metav1.ListOptions{LabelSelector: labelSelector})
-		if err != nil {
-			return reconcile.Result{}, errors.Wrapf(err, "failed to delete secret collection of deleted plan [namespace:%s,selector:%s]", dstNamespace, labelSelector)
-		}
-		log.Info(fmt.Sprintf("succeeded to delete secret collection of deleted plan [namespace:%s,selector:%s]", dstNamespace, labelSelector))
+		// The synced secrets have been deleted by the finalizer.
+		setPlanFollowed(request.NamespacedName, false)
 		return reconcile.Result{}, nil
 	} else if err != nil {
 		return reconcile.Result{}, errors.Wrapf(err, "failed to get plan %s", request.NamespacedName)
 	}
+	spec := plan.GetSpec()
+	status := plan.GetStatus()
 
-	// Following is operation for Secrets.
+	if plan.GetDeletionTimestamp().IsZero() {
+		// Register the finalizer to delete the synced secrets before the Plan is removed.
+		if !util.Contains(planFinalizerName, plan.GetFinalizers()) {
+			plan.SetFinalizers(append(plan.GetFinalizers(), planFinalizerName))
+			if err := util.ReconcilesUpdatePlan(r, context.TODO(), plan); err != nil {
+				return reconcile.Result{}, errors.Wrapf(err, "failed to add finalizer to plan [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName())
+			}
+		}
+	} else {
+		// Plan Deleted
+		// Cache skips the Plan from now on, so the secret controllers do not restore the secrets being deleted.
+		setPlanFollowed(request.NamespacedName, false)
+		if !util.Contains(planFinalizerName, plan.GetFinalizers()) {
+			return reconcile.Result{}, nil
+		}
+		log.Info(fmt.Sprintf("plan deleted [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName()))
+		lastGVK := status.GetLastGroupVersionKind()
+		switch {
+		case !clientset.IsSyncKind(lastGVK):
+			// Never block the deletion of the plan, whose objects the manager can no longer see.
+			log.Info(fmt.Sprintf("skipped to delete objects of kind not configured to sync [namespace:%s,name:%s,kind:%s]", plan.GetNamespace(), plan.GetName(), lastGVK))
+		case status.GetLastMode() == riggerv1beta1.PlanModeDistribute:
+			if err := DeleteDistributedSecrets(plan, lastGVK); err != nil {
+				return reconcile.Result{}, errors.Wrapf(err, "failed to delete distributed secrets of deleted plan [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName())
+			}
+		case status.GetLastMode() == riggerv1beta1.PlanModeMerge:
+			if err := DeleteMergedSecrets(plan, lastGVK); err != nil {
+				return reconcile.Result{}, errors.Wrapf(err, "failed to delete merged secrets of deleted plan [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName())
+			}
+		default:
+			dstNamespace := status.LastSyncDestNamespace
+			if dstNamespace == "" {
+				dstNamespace = spec.SyncDestNamespace
+			}
+			// Delete only the secrets synced by the deleted plan, other plans may share the destination.
+			if err := DeleteSyncedSecrets(plan, lastGVK, dstNamespace); err != nil {
+				return reconcile.Result{}, errors.Wrapf(err, "failed to delete synced secrets of deleted plan [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName())
+			}
+		}
+		deletePlanMetrics(request.NamespacedName)
+		plan.SetFinalizers(util.Remove(planFinalizerName, plan.GetFinalizers()))
+		if err := util.ReconcilesUpdatePlan(r, context.TODO(), plan); err != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "failed to remove finalizer from plan [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName())
+		}
+		return reconcile.Result{}, nil
+	}
 
-	// Update Plan cache to avoid running Reconcile loops with old settings.
-	Cache.Store(plan.Name, plan)
+	// Mode or Kind Updated
+	// Delete the objects synced in the last mode or of the last kind, and sync from scratch.
+	if lastGVK := status.GetLastGroupVersionKind(); status.GetLastMode() != spec.GetMode() || lastGVK != spec.GetGroupVersionKind() {
+		log.Info(fmt.Sprintf("plan mode updated [namespace:%s,name:%s,mode:%s,kind:%s]", plan.GetNamespace(), plan.GetName(), spec.GetMode(), spec.GetGroupVersionKind()))
+		switch {
+		case !clientset.IsSyncKind(lastGVK):
+			log.Info(fmt.Sprintf("skipped to delete objects of kind not configured to sync [namespace:%s,name:%s,kind:%s]", plan.GetNamespace(), plan.GetName(), lastGVK))
+		case status.GetLastMode() == riggerv1beta1.PlanModeCollect:
+			if status.LastSyncDestNamespace != "" {
+				if err := DeleteSyncedSecrets(plan, lastGVK, status.LastSyncDestNamespace); err != nil {
+					return reconcile.Result{}, errors.Wrapf(err, "failed to delete synced secrets [destnamespace:%s]", status.LastSyncDestNamespace)
+				}
+			}
+		case status.GetLastMode() == riggerv1beta1.PlanModeDistribute:
+			if err := DeleteDistributedSecrets(plan, lastGVK); err != nil {
+				return reconcile.Result{}, errors.Wrapf(err, "failed to delete distributed secrets [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName())
+			}
+		case status.GetLastMode() == riggerv1beta1.PlanModeMerge:
+			if err := DeleteMergedSecrets(plan, lastGVK); err != nil {
+				return reconcile.Result{}, errors.Wrapf(err, "failed to delete merged secrets [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName())
+			}
+		}
+		apiVersion, kind := spec.GetGroupVersionKind().ToAPIVersionAndKind()
+		*status = riggerv1beta1.PlanStatus{LastMode: spec.GetMode(), LastAPIVersion: apiVersion, LastKind: kind}
+		if err := r.updatePlanStatus(plan); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
 
-	newSyncTargetSecretName := plan.Spec.SyncTargetSecretName
-	newSyncDestNamespace := plan.Spec.SyncDestNamespace
-	newIgnoreNamespaces := plan.Spec.IgnoreNamespaces
+	setPlanFollowed(request.NamespacedName, inScope(plan))
+	if !inScope(plan) {
+		log.Info(fmt.Sprintf("plan out of its namespace is ignored, use ClusterPlan instead [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName()))
+		status.SetCondition(riggerv1beta1.PlanReady, corev1.ConditionFalse, "OutOfScope", "Plan can sync secrets only within its own namespace, use ClusterPlan instead")
+		status.ObservedGeneration = plan.GetGeneration()
+		if err := r.updatePlanStatus(plan); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{}, nil
+	}
+
+	var synced bool
+	var failures []riggerv1beta1.SyncFailure
+	switch spec.GetMode() {
+	case riggerv1beta1.PlanModeDistribute:
+		synced, failures, err = r.reconcileDistribution(request, plan)
+	case riggerv1beta1.PlanModeMerge:
+		synced, failures, err = r.reconcileMerge(request, plan)
+	default:
+		synced, failures, err = r.reconcileCollection(request, plan)
+	}
+	if !synced && err == nil && status.ObservedGeneration == plan.GetGeneration() {
+		// Export the status of the last sync, which may have been done before restarting.
+		setPlanMetrics(request.NamespacedName, status)
+		return reconcile.Result{}, nil
+	}
+	if err := r.updateSyncStatus(request, plan, failures, err); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if len(failures) > 0 {
+		// Retry the failed namespaces later.
+		return reconcile.Result{RequeueAfter: degradedRequeueAfter}, nil
+	}
+	return reconcile.Result{}, nil
+}
+
+// reconcileCollection syncs the target secrets of the plan in Collect mode to SyncDestNamespace step by step,
+// following the changes of the spec since the last sync. synced is false if there is nothing to sync.
+func (r *ReconcilePlan) reconcileCollection(request reconcile.Request, plan riggerv1beta1.PlanObject) (synced bool, failures []riggerv1beta1.SyncFailure, err error) {
+	spec := plan.GetSpec()
+	status := plan.GetStatus()
+
+	newSyncTargetSecretName := spec.SyncTargetSecretName
+	newSyncTargets := spec.SyncTargets
+	newDestinationNameTemplate := spec.DestinationNameTemplate
+	opts := riggertypes.NewDstSecretOptions(spec)
+	newSyncDestNamespace := spec.SyncDestNamespace
+	newIgnoreNamespaces := spec.IgnoreNamespaces
+	newIncludeNamespaces := spec.IncludeNamespaces
+	newNamespaceSelector := spec.NamespaceSelector
 
 	// Plan Cretated
-	if len(plan.Status.LastSyncTargetSecretName)+len(plan.Status.LastSyncDestNamespace)+len(plan.Status.LastIgnoreNamespaces) == 0 {
-		log.Info(fmt.Sprintf("plan created [namespace:%s,name:%s]", plan.Namespace, plan.Name))
-		if err := SyncAllNamespaceSecrets(newSyncTargetSecretName, newSyncDestNamespace, newIgnoreNamespaces); err != nil {
-			return reconcile.Result{}, errors.Wrapf(err, "failed to sync all namespace secrets to [destnamespace:%s,targetname:%s]", newSyncTargetSecretName, newSyncDestNamespace)
-		}
-		log.Info(fmt.Sprintf("succeeded to sync all namespace secrets to [destnamespace:%s,targetname:%s]", newSyncTargetSecretName, newSyncDestNamespace))
-		plan.Status.LastSyncTargetSecretName = newSyncTargetSecretName
-		plan.Status.LastSyncDestNamespace = newSyncDestNamespace
-		plan.Status.LastIgnoreNamespaces = newIgnoreNamespaces
-		if err := util.ReconcilesUpdatePlan(r, context.TODO(), plan); err != nil {
-			return reconcile.Result{}, errors.Wrapf(err, "failed to update plan status [namespace:%s,name:%s]", plan.Namespace, plan.Name)
+	if len(status.LastSyncTargetSecretName)+len(status.LastSyncTargets)+len(status.LastSyncDestNamespace)+len(status.LastIgnoreNamespaces) == 0 {
+		log.Info(fmt.Sprintf("plan created [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName()))
+		failures, err := SyncAllNamespaceSecrets(plan, spec.GetSyncTargets(), opts, newSyncDestNamespace, util.NewNamespaceFilter(plan))
+		if err != nil {
+			return true, nil, errors.Wrapf(err, "failed to sync all namespace secrets to [destnamespace:%s]", newSyncDestNamespace)
 		}
-		Cache.Store(plan.Name, plan)
-		log.Info(fmt.Sprintf("succeeded to update plan status [namespace:%s,name:%s]", plan.Namespace, plan.Name))
-		return reconcile.Result{}, nil
+		log.Info(fmt.Sprintf("succeeded to sync all namespace secrets to [destnamespace:%s]", newSyncDestNamespace))
+		status.LastSyncTargetSecretName = newSyncTargetSecretName
+		status.LastSyncTargets = newSyncTargets
+		status.LastDestinationNameTemplate = newDestinationNameTemplate
+		status.LastSyncDestNamespace = newSyncDestNamespace
+		status.LastIgnoreNamespaces = newIgnoreNamespaces
+		status.LastIncludeNamespaces = newIncludeNamespaces
+		status.LastNamespaceSelector = newNamespaceSelector
+		return true, failures, nil
 	}
 
 	// Plan Updated
-	SyncTargetSecretNameUpdated := plan.Status.LastSyncTargetSecretName != newSyncTargetSecretName
-	SyncDestNamespaceUpdated := plan.Status.LastSyncDestNamespace != newSyncDestNamespace
-	IgnoreNamespacesUpdated := !reflect.DeepEqual(plan.Status.LastIgnoreNamespaces, newIgnoreNamespaces)
-	if !(SyncTargetSecretNameUpdated || SyncDestNamespaceUpdated || IgnoreNamespacesUpdated) {
-		return reconcile.Result{}, nil
+	SyncTargetsUpdated := status.LastSyncTargetSecretName != newSyncTargetSecretName ||
+		!reflect.DeepEqual(status.LastSyncTargets, newSyncTargets) ||
+		status.LastDestinationNameTemplate != newDestinationNameTemplate
+	SyncDestNamespaceUpdated := status.LastSyncDestNamespace != newSyncDestNamespace
+	IgnoreNamespacesUpdated := !reflect.DeepEqual(status.LastIgnoreNamespaces, newIgnoreNamespaces)
+	SelectedNamespacesUpdated := !reflect.DeepEqual(status.LastIncludeNamespaces, newIncludeNamespaces) ||
+		!reflect.DeepEqual(status.LastNamespaceSelector, newNamespaceSelector)
+	if !(SyncTargetsUpdated || SyncDestNamespaceUpdated || IgnoreNamespacesUpdated || SelectedNamespacesUpdated) {
+		if !isDegraded(status) && status.ObservedGeneration == plan.GetGeneration() {
+			return false, nil, nil
+		}
+		// Retry the namespaces which failed to sync last time,
+		// or apply the changes of the fields not tracked in the status such as PropagateLabels.
+		destNamespace := status.LastSyncDestNamespace
+		failures, err := SyncAllNamespaceSecrets(plan, spec.GetSyncTargets(), opts, destNamespace, util.NewNamespaceFilter(plan))
+		if err != nil {
+			return true, nil, errors.Wrapf(err, "failed to sync all namespace secrets to [destnamespace:%s]", destNamespace)
+		}
+		return true, failures, nil
 	}
-	log.Info(fmt.Sprintf("plan updated [namespace:%s,name:%s]", plan.Namespace, plan.Name))
-	if SyncTargetSecretNameUpdated {
-		// Update SyncTargetSecretName
-		// TODO(unblee): Sync new SyncTargetSecretName secrets of all namespaces to SyncDestNamespace
-		//               && Delete all old SyncTargetSecretName secrets from SyncDestNamespace
-		plan.Status.LastSyncTargetSecretName = newSyncTargetSecretName
+	log.Info(fmt.Sprintf("plan updated [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName()))
+	if SyncTargetsUpdated {
+		// Update SyncTargetSecretName, SyncTargets or DestinationNameTemplate
+		// Sync secrets of new targets of all namespaces to SyncDestNamespace by the new names
+		// && Delete secrets no longer matching the targets or the names from SyncDestNamespace
+		destNamespace := status.LastSyncDestNamespace
+		targets := spec.GetSyncTargets()
+		f, err := SyncAllNamespaceSecrets(plan, targets, opts, destNamespace, lastNamespaceFilter(plan))
+		if err != nil {
+			return true, failures, errors.Wrapf(err, "failed to sync all namespace secrets to [destnamespace:%s]", destNamespace)
+		}
+		failures = append(failures, f...)
+		log.Info(fmt.Sprintf("succeeded to sync all namespace secrets to [destnamespace:%s]", destNamespace))
+		if err := PruneSyncedSecrets(plan, targets, opts, destNamespace, lastNamespaceFilter(plan)); err != nil {
+			return true, failures, errors.Wrapf(err, "failed to delete synced secrets of old targets [destnamespace:%s]", destNamespace)
+		}
+		status.LastSyncTargetSecretName = newSyncTargetSecretName
+		status.LastSyncTargets = newSyncTargets
+		status.LastDestinationNameTemplate = newDestinationNameTemplate
+		// Record progress so that an interrupted migration resumes from the next step.
+		if err := r.updatePlanStatus(plan); err != nil {
+			return true, failures, err
+		}
 	}
 	if SyncDestNamespaceUpdated {
 		// Update SyncDestNamespace
-		// TODO(unblee): Sync SyncTargetSecretName secrets of all namespaces to new SyncDestNamespace
-		//               && Delete all SyncTargetSecretName secrets from old SyncDestNamespace
-		plan.Status.LastSyncDestNamespace = newSyncDestNamespace
+		// Sync secrets of the targets of all namespaces to new SyncDestNamespace
+		// && Delete all synced secrets from old SyncDestNamespace
+		targets := lastSyncTargets(plan)
+		f, err := SyncAllNamespaceSecrets(plan, targets, lastDstSecretOptions(plan), newSyncDestNamespace, lastNamespaceFilter(plan))
+		if err != nil {
+			return true, failures, errors.Wrapf(err, "failed to sync all namespace secrets to [destnamespace:%s]", newSyncDestNamespace)
+		}
+		failures = append(failures, f...)
+		log.Info(fmt.Sprintf("succeeded to sync all namespace secrets to [destnamespace:%s]", newSyncDestNamespace))
+		if err := DeleteSyncedSecrets(plan, spec.GetGroupVersionKind(), status.LastSyncDestNamespace); err != nil {
+			return true, failures, errors.Wrapf(err, "failed to delete synced secrets of old dest namespace [destnamespace:%s]", status.LastSyncDestNamespace)
+		}
+		status.LastSyncDestNamespace = newSyncDestNamespace
+		if err := r.updatePlanStatus(plan); err != nil {
+			return true, failures, err
+		}
 	}
 	if IgnoreNamespacesUpdated {
 		// Update IgnoreNamespaces
-		// TODO(unblee):
-		//   added ignore namespaces: Delete all SyncTargetSecretName secrets from added IgnoreNamespaces namespace
-		//   deleted ignore namespaces: Sync SyncTargetSecretName secrets of all namespaces to deleted IgnoreNamespaces namespaces
-		plan.Status.LastIgnoreNamespaces = newIgnoreNamespaces
+		// IgnoreNamespaces may hold patterns, so the changes are applied to every namespace they match.
+		//   added ignore namespaces: Delete secrets of newly ignored namespaces from SyncDestNamespace
+		//   deleted ignore namespaces: Sync secrets of no longer ignored namespaces to SyncDestNamespace
+		targets := lastSyncTargets(plan)
+		destNamespace := status.LastSyncDestNamespace
+		filter := lastNamespaceFilter(plan)
+		filter.IgnoreNamespaces = newIgnoreNamespaces
+		added, deleted := util.Diff(status.LastIgnoreNamespaces, newIgnoreNamespaces)
+		if len(added) > 0 {
+			if err := PruneSyncedSecrets(plan, targets, lastDstSecretOptions(plan), destNamespace, filter); err != nil {
+				return true, failures, errors.Wrapf(err, "failed to delete synced secrets of ignored namespaces [destnamespace:%s,ignorenamespaces:%v]", destNamespace, added)
+			}
+		}
+		if len(deleted) > 0 {
+			f, err := SyncAllNamespaceSecrets(plan, targets, lastDstSecretOptions(plan), destNamespace, filter)
+			if err != nil {
+				return true, failures, errors.Wrapf(err, "failed to sync secrets of unignored namespaces [destnamespace:%s,ignorenamespaces:%v]", destNamespace, deleted)
+			}
+			failures = append(failures, f...)
+		}
+		status.LastIgnoreNamespaces = newIgnoreNamespaces
+		if err := r.updatePlanStatus(plan); err != nil {
+			return true, failures, err
+		}
 	}
-	if err := util.ReconcilesUpdatePlan(r, context.TODO(), plan); err != nil {
-		return reconcile.Result{}, errors.Wrapf(err, "failed to update plan status [namespace:%s,name:%s]", plan.Namespace, plan.Name)
+	if SelectedNamespacesUpdated {
+		// Update IncludeNamespaces or NamespaceSelector
+		// Sync secrets of newly selected namespaces to SyncDestNamespace
+		// && Delete secrets of unselected namespaces from SyncDestNamespace
+		targets := lastSyncTargets(plan)
+		destNamespace := status.LastSyncDestNamespace
+		filter := util.NewNamespaceFilter(plan)
+		f, err := SyncAllNamespaceSecrets(plan, targets, lastDstSecretOptions(plan), destNamespace, filter)
+		if err != nil {
+			return true, failures, errors.Wrapf(err, "failed to sync all namespace secrets to [destnamespace:%s]", destNamespace)
+		}
+		failures = append(failures, f...)
+		if err := PruneSyncedSecrets(plan, targets, lastDstSecretOptions(plan), destNamespace, filter); err != nil {
+			return true, failures, errors.Wrapf(err, "failed to prune synced secrets of unselected namespaces [destnamespace:%s]", destNamespace)
+		}
+		status.LastIncludeNamespaces = newIncludeNamespaces
+		status.LastNamespaceSelector = newNamespaceSelector
 	}
-	Cache.Store(plan.Name, plan)
-	log.Info(fmt.Sprintf("succeeded to update plan status [namespace:%s,name:%s]", plan.Namespace, plan.Name))
-	return reconcile.Result{}, nil
+	return true, failures, nil
 }
 
-func SyncAllNamespaceSecrets(targetSecretName, destNamespace string, ignoreNamespaces []string) error {
-	allNamespaceSecrets, err := clientset.GetAllNamespaceSecrets()
+// reconcileDistribution syncs the source secret of the plan to the selected namespaces
+// and deletes the secrets synced to the unselected ones. synced is false if there is nothing to sync.
+func (r *ReconcilePlan) reconcileDistribution(request reconcile.Request, plan riggerv1beta1.PlanObject) (synced bool, failures []riggerv1beta1.SyncFailure, err error) {
+	spec := plan.GetSpec()
+	status := plan.GetStatus()
+	if spec.Source == nil {
+		log.Info(fmt.Sprintf("plan has no source to distribute [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName()))
+		return false, nil, nil
+	}
+	if reflect.DeepEqual(status.LastSource, spec.Source) &&
+		reflect.DeepEqual(status.LastIgnoreNamespaces, spec.IgnoreNamespaces) &&
+		reflect.DeepEqual(status.LastIncludeNamespaces, spec.IncludeNamespaces) &&
+		reflect.DeepEqual(status.LastNamespaceSelector, spec.NamespaceSelector) &&
+		status.ObservedGeneration == plan.GetGeneration() &&
+		!isDegraded(status) {
+		return false, nil, nil
+	}
+	source := spec.Source
+	filter := util.NewNamespaceFilter(plan)
+	failures, err = DistributeAllNamespaceSecrets(plan, source, riggertypes.NewDstSecretOptions(spec), filter)
 	if err != nil {
-		return errors.Wrap(err, "failed to get secrets of all namespace")
+		return true, nil, errors.Wrapf(err, "failed to distribute secret [namespace:%s,name:%s]", source.Namespace, source.Name)
 	}
-	for _, srcSecret := range allNamespaceSecrets {
-		if srcSecret.Name != targetSecretName || util.Contains(srcSecret.Namespace, ignoreNamespaces) {
-			continue
-		}
-		dstSecret := riggertypes.NewDstSecret(destNamespace, riggertypes.NewDstSecretName(srcSecret.Namespace, srcSecret.Name), &srcSecret)
-		_, err := clientset.CreateSecret(dstSecret.Namespace, dstSecret)
-		if apierrors.IsAlreadyExists(err) {
-			// Overwrite the existing Secret.
-			_, _ = clientset.UpdateSecret(dstSecret.Namespace, dstSecret)
-			log.Info(fmt.Sprintf("succeeded to update secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
-		} else if err != nil {
-			return errors.Wrapf(err, "failed to create secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
-		} else {
-			log.Info(fmt.Sprintf("succeeded to create secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+	log.Info(fmt.Sprintf("succeeded to distribute secret [namespace:%s,name:%s]", source.Namespace, source.Name))
+	if err := PruneDistributedSecrets(plan, spec.GetGroupVersionKind(), source, filter); err != nil {
+		return true, failures, errors.Wrapf(err, "failed to prune distributed secrets [namespace:%s,name:%s]", source.Namespace, source.Name)
+	}
+	status.LastSource = source
+	status.LastIgnoreNamespaces = spec.IgnoreNamespaces
+	status.LastIncludeNamespaces = spec.IncludeNamespaces
+	status.LastNamespaceSelector = spec.NamespaceSelector
+	return true, failures, nil
+}
+
+// reconcileMerge rebuilds the secret into which the plan merges the target secrets, and deletes the secrets merged
+// under an old name or into an old namespace. synced is false if there is nothing to sync.
+func (r *ReconcilePlan) reconcileMerge(request reconcile.Request, plan riggerv1beta1.PlanObject) (synced bool, failures []riggerv1beta1.SyncFailure, err error) {
+	spec := plan.GetSpec()
+	status := plan.GetStatus()
+	// The src-secret-controller rebuilds the merged secret as the sources change, so only the changes of the spec are followed here.
+	if status.ObservedGeneration == plan.GetGeneration() && !isDegraded(status) {
+		return false, nil, nil
+	}
+	destNamespace := spec.SyncDestNamespace
+	dstName := util.MergedSecretName(plan)
+	_, err = MergeSecrets(r, plan)
+	setRenderedCondition(plan, err)
+	if err != nil {
+		return true, nil, err
+	}
+	log.Info(fmt.Sprintf("succeeded to merge secrets into [namespace:%s,name:%s]", destNamespace, dstName))
+	if err := PruneMergedSecrets(plan, spec.GetGroupVersionKind(), destNamespace, dstName); err != nil {
+		return true, nil, errors.Wrapf(err, "failed to prune merged secrets [namespace:%s,name:%s]", destNamespace, dstName)
+	}
+	status.LastSyncDestNamespace = destNamespace
+	return true, nil, nil
+}
+
+// updateSyncStatus records the result of the sync on the status of the plan and persists it.
+func (r *ReconcilePlan) updateSyncStatus(request reconcile.Request, plan riggerv1beta1.PlanObject, failures []riggerv1beta1.SyncFailure, syncErr error) error {
+	status := plan.GetStatus()
+	status.ObservedGeneration = plan.GetGeneration()
+	now := metav1.Now()
+	status.LastSyncTime = &now
+
+	var count int
+	var err error
+	gvk := plan.GetSpec().GetGroupVersionKind()
+	switch plan.GetSpec().GetMode() {
+	case riggerv1beta1.PlanModeDistribute:
+		count, err = CountDistributedSecrets(request.NamespacedName, gvk)
+	case riggerv1beta1.PlanModeMerge:
+		count, err = CountMergedSecrets(request.NamespacedName, gvk)
+	default:
+		count, err = CountSyncedSecrets(request.NamespacedName, gvk, status.LastSyncDestNamespace)
+	}
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to count synced secrets [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName()))
+	} else {
+		status.SyncedSecrets = int32(count)
+	}
+
+	status.Failures = failures
+	if len(status.Failures) > riggerv1beta1.MaxSyncFailures {
+		status.Failures = status.Failures[:riggerv1beta1.MaxSyncFailures]
+	}
+
+	switch {
+	case syncErr != nil:
+		recorder.Event(plan, corev1.EventTypeWarning, ReasonSyncFailed, syncErr.Error())
+		countSecretOperation(request.NamespacedName, OperationFailed)
+		status.SetCondition(riggerv1beta1.PlanSynced, corev1.ConditionFalse, "SyncFailed", syncErr.Error())
+		status.SetCondition(riggerv1beta1.PlanReady, corev1.ConditionFalse, "SyncFailed", syncErr.Error())
+	case len(failures) > 0:
+		message := fmt.Sprintf("%d namespaces failed to sync", len(failures))
+		status.SetCondition(riggerv1beta1.PlanSynced, corev1.ConditionTrue, "SyncSucceeded", "")
+		status.SetCondition(riggerv1beta1.PlanDegraded, corev1.ConditionTrue, "NamespacesFailed", message)
+		status.SetCondition(riggerv1beta1.PlanReady, corev1.ConditionFalse, "NamespacesFailed", message)
+	default:
+		status.SetCondition(riggerv1beta1.PlanSynced, corev1.ConditionTrue, "SyncSucceeded", "")
+		status.SetCondition(riggerv1beta1.PlanDegraded, corev1.ConditionFalse, "SyncSucceeded", "")
+		status.SetCondition(riggerv1beta1.PlanReady, corev1.ConditionTrue, "SyncSucceeded", "")
+	}
+
+	loop, err := detectLoop(r, plan)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to detect sync loop [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName()))
+	} else if loop != "" {
+		if c := status.GetCondition(riggerv1beta1.PlanLoopDetected); c == nil || c.Status != corev1.ConditionTrue {
+			recorder.Event(plan, corev1.EventTypeWarning, ReasonLoopDetected, loop)
 		}
+		status.SetCondition(riggerv1beta1.PlanLoopDetected, corev1.ConditionTrue, ReasonLoopDetected, loop)
+	} else {
+		status.SetCondition(riggerv1beta1.PlanLoopDetected, corev1.ConditionFalse, "NoLoop", "")
+	}
+
+	setPlanMetrics(request.NamespacedName, status)
+	return r.updatePlanStatus(plan)
+}
+
+// updatePlanStatus persists the Plan status through the status subresource.
+func (r *ReconcilePlan) updatePlanStatus(plan riggerv1beta1.PlanObject) error {
+	if err := util.ReconcilesUpdatePlanStatus(r, context.TODO(), plan); err != nil {
+		return errors.Wrapf(err, "failed to update plan status [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName())
 	}
+	log.Info(fmt.Sprintf("succeeded to update plan status [namespace:%s,name:%s]", plan.GetNamespace(), plan.GetName()))
 	return nil
 }
+
+// isDegraded reports whether some namespaces failed to sync last time.
+func isDegraded(status *riggerv1beta1.PlanStatus) bool {
+	c := status.GetCondition(riggerv1beta1.PlanDegraded)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
+
+// lastSyncTargets returns the targets which the synced secrets of the plan currently follow.
+func lastSyncTargets(plan riggerv1beta1.PlanObject) []riggerv1beta1.SyncTarget {
+	spec := &riggerv1beta1.PlanSpec{
+		SyncTargetSecretName: plan.GetStatus().LastSyncTargetSecretName,
+		SyncTargets:          plan.GetStatus().LastSyncTargets,
+	}
+	return spec.GetSyncTargets()
+}
+
+// lastDstSecretOptions returns the DstSecretOptions which the names of the synced secrets of the plan currently follow.
+func lastDstSecretOptions(plan riggerv1beta1.PlanObject) riggertypes.DstSecretOptions {
+	opts := riggertypes.NewDstSecretOptions(plan.GetSpec())
+	opts.NameTemplate = plan.GetStatus().LastDestinationNameTemplate
+	return opts
+}
+
+// lastNamespaceFilter returns the NamespaceFilter which the synced secrets of the plan currently follow.
+func lastNamespaceFilter(plan riggerv1beta1.PlanObject) util.NamespaceFilter {
+	return util.NamespaceFilter{
+		Namespace:         plan.GetNamespace(),
+		IgnoreNamespaces:  plan.GetStatus().LastIgnoreNamespaces,
+		IncludeNamespaces: plan.GetStatus().LastIncludeNamespaces,
+		NamespaceSelector: plan.GetStatus().LastNamespaceSelector,
+	}
+}
+
+// IsMigratingDestination reports whether the plan in Collect mode is still moving its synced secrets to a new syncDestNamespace.
+// Nothing else should sync the secrets of the plan meanwhile, since the migration syncs every source to the new destination
+// and deletes the old one by itself.
+func IsMigratingDestination(plan riggerv1beta1.PlanObject) bool {
+	last := plan.GetStatus().LastSyncDestNamespace
+	return plan.GetSpec().GetMode() == riggerv1beta1.PlanModeCollect && last != "" && last != plan.GetSpec().SyncDestNamespace
+}
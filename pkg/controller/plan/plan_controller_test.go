@@ -0,0 +1,364 @@
+package plan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	"github.com/wantedly/rigger/pkg/clientset"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const timeout = time.Second * 10
+
+// setUp starts a manager running the plan-controller, and returns the client to the API server and the function stopping it.
+func setUp(t *testing.T, g *gomega.GomegaWithT) (client.Client, func()) {
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(Add(mgr)).NotTo(gomega.HaveOccurred())
+	c, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	stop, wg := StartTestManager(mgr, g)
+	return c, func() {
+		close(stop)
+		wg.Wait()
+	}
+}
+
+// createNamespace creates the namespace of name with the secrets of secretNames in it.
+func createNamespace(g *gomega.GomegaWithT, c client.Client, name string, secretNames ...string) {
+	g.Expect(c.Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})).NotTo(gomega.HaveOccurred())
+	for _, secretName := range secretNames {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: name, Name: secretName},
+			Data:       map[string][]byte{"token": []byte(name)},
+		}
+		g.Expect(c.Create(context.TODO(), secret)).NotTo(gomega.HaveOccurred())
+	}
+}
+
+// secretExists returns the function reporting whether the secret of namespace and name exists, for Eventually.
+func secretExists(namespace, name string) func() bool {
+	return func() bool {
+		_, err := clientset.Objects(riggertypes.SecretGroupVersionKind).Get(namespace, name)
+		return err == nil
+	}
+}
+
+func TestReconcileDeletesOnlyOwnSecretsOnDeletion(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	c, stop := setUp(t, g)
+	defer stop()
+
+	createNamespace(g, c, "finalizer", "token", "other-token")
+
+	// Both plans sync into the same destination.
+	instance := &riggerv1beta1.Plan{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "finalizer", Name: "plan"},
+		Spec:       riggerv1beta1.PlanSpec{SyncTargetSecretName: "token", SyncDestNamespace: "finalizer"},
+	}
+	other := &riggerv1beta1.Plan{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "finalizer", Name: "other"},
+		Spec:       riggerv1beta1.PlanSpec{SyncTargetSecretName: "other-token", SyncDestNamespace: "finalizer"},
+	}
+	g.Expect(c.Create(context.TODO(), instance)).NotTo(gomega.HaveOccurred())
+	g.Expect(c.Create(context.TODO(), other)).NotTo(gomega.HaveOccurred())
+
+	copyName := riggertypes.NewDstSecretName("finalizer", "token").String()
+	otherCopyName := riggertypes.NewDstSecretName("finalizer", "other-token").String()
+	g.Eventually(secretExists("finalizer", copyName), timeout).Should(gomega.BeTrue())
+	g.Eventually(secretExists("finalizer", otherCopyName), timeout).Should(gomega.BeTrue())
+
+	key := types.NamespacedName{Namespace: "finalizer", Name: "plan"}
+	g.Eventually(func() []string {
+		plan := &riggerv1beta1.Plan{}
+		if err := c.Get(context.TODO(), key, plan); err != nil {
+			return nil
+		}
+		return plan.Finalizers
+	}, timeout).Should(gomega.ContainElement(planFinalizerName))
+
+	// The finalizer deletes the copies of the plan, and only them, before the plan is removed.
+	g.Expect(c.Delete(context.TODO(), instance)).NotTo(gomega.HaveOccurred())
+	g.Eventually(secretExists("finalizer", copyName), timeout).Should(gomega.BeFalse())
+	g.Eventually(func() bool {
+		return apierrors.IsNotFound(c.Get(context.TODO(), key, &riggerv1beta1.Plan{}))
+	}, timeout).Should(gomega.BeTrue())
+	g.Consistently(secretExists("finalizer", otherCopyName), time.Second).Should(gomega.BeTrue())
+}
+
+func TestReconcilePrunesSecretsOnModeSwitch(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	c, stop := setUp(t, g)
+	defer stop()
+
+	createNamespace(g, c, "switch-src", "token")
+	createNamespace(g, c, "switch-dst")
+	createNamespace(g, c, "switch-target")
+
+	instance := &riggerv1beta1.ClusterPlan{
+		ObjectMeta: metav1.ObjectMeta{Name: "switch"},
+		Spec: riggerv1beta1.PlanSpec{
+			SyncTargetSecretName: "token",
+			SyncDestNamespace:    "switch-dst",
+			IncludeNamespaces:    []string{"switch-src"},
+		},
+	}
+	g.Expect(c.Create(context.TODO(), instance)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), instance)
+
+	copyName := riggertypes.NewDstSecretName("switch-src", "token").String()
+	g.Eventually(secretExists("switch-dst", copyName), timeout).Should(gomega.BeTrue())
+
+	// Switching to Distribute prunes the collected copy, and distributes the source instead.
+	key := types.NamespacedName{Name: "switch"}
+	g.Eventually(func() error {
+		if err := c.Get(context.TODO(), key, instance); err != nil {
+			return err
+		}
+		instance.Spec.Mode = riggerv1beta1.PlanModeDistribute
+		instance.Spec.Source = &corev1.SecretReference{Namespace: "switch-src", Name: "token"}
+		instance.Spec.IncludeNamespaces = []string{"switch-target"}
+		return c.Update(context.TODO(), instance)
+	}, timeout).Should(gomega.Succeed())
+
+	g.Eventually(secretExists("switch-dst", copyName), timeout).Should(gomega.BeFalse())
+	g.Eventually(secretExists("switch-target", "token"), timeout).Should(gomega.BeTrue())
+}
+
+func TestReconcileSyncsPlanOnlyWithinItsNamespace(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	c, stop := setUp(t, g)
+	defer stop()
+
+	createNamespace(g, c, "scope-own", "token")
+	createNamespace(g, c, "scope-other", "token")
+
+	instance := &riggerv1beta1.Plan{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "scope-own", Name: "plan"},
+		Spec:       riggerv1beta1.PlanSpec{SyncTargetSecretName: "token", SyncDestNamespace: "scope-own"},
+	}
+	g.Expect(c.Create(context.TODO(), instance)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), instance)
+
+	// The secrets of the other namespace are never synced by a Plan.
+	g.Eventually(secretExists("scope-own", riggertypes.NewDstSecretName("scope-own", "token").String()), timeout).Should(gomega.BeTrue())
+	g.Consistently(secretExists("scope-own", riggertypes.NewDstSecretName("scope-other", "token").String()), time.Second).Should(gomega.BeFalse())
+
+	// Nor into another namespace, which is reported as out of scope.
+	outOfScope := &riggerv1beta1.Plan{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "scope-own", Name: "out-of-scope"},
+		Spec:       riggerv1beta1.PlanSpec{SyncTargetSecretName: "token", SyncDestNamespace: "scope-other"},
+	}
+	g.Expect(c.Create(context.TODO(), outOfScope)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), outOfScope)
+
+	key := types.NamespacedName{Namespace: "scope-own", Name: "out-of-scope"}
+	g.Eventually(func() string {
+		plan := &riggerv1beta1.Plan{}
+		if err := c.Get(context.TODO(), key, plan); err != nil {
+			return ""
+		}
+		if cond := plan.Status.GetCondition(riggerv1beta1.PlanReady); cond != nil && cond.Status == corev1.ConditionFalse {
+			return cond.Reason
+		}
+		return ""
+	}, timeout).Should(gomega.Equal("OutOfScope"))
+	g.Consistently(secretExists("scope-other", riggertypes.NewDstSecretName("scope-own", "token").String()), time.Second).Should(gomega.BeFalse())
+}
+
+// updateClusterPlan applies update to the latest ClusterPlan of name until it succeeds.
+func updateClusterPlan(g *gomega.GomegaWithT, c client.Client, name string, update func(*riggerv1beta1.ClusterPlan)) {
+	g.Eventually(func() error {
+		instance := &riggerv1beta1.ClusterPlan{}
+		if err := c.Get(context.TODO(), types.NamespacedName{Name: name}, instance); err != nil {
+			return err
+		}
+		update(instance)
+		return c.Update(context.TODO(), instance)
+	}, timeout).Should(gomega.Succeed())
+}
+
+func TestReconcileMigratesSecretsOnTargetChange(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	c, stop := setUp(t, g)
+	defer stop()
+
+	createNamespace(g, c, "rename-src", "token", "new-token")
+	createNamespace(g, c, "rename-dst")
+
+	instance := &riggerv1beta1.ClusterPlan{
+		ObjectMeta: metav1.ObjectMeta{Name: "rename"},
+		Spec: riggerv1beta1.PlanSpec{
+			SyncTargetSecretName: "token",
+			SyncDestNamespace:    "rename-dst",
+			IncludeNamespaces:    []string{"rename-src"},
+		},
+	}
+	g.Expect(c.Create(context.TODO(), instance)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), instance)
+
+	copyName := riggertypes.NewDstSecretName("rename-src", "token").String()
+	g.Eventually(secretExists("rename-dst", copyName), timeout).Should(gomega.BeTrue())
+
+	// Renaming the target syncs the new secret and deletes the copy of the old one.
+	updateClusterPlan(g, c, "rename", func(instance *riggerv1beta1.ClusterPlan) {
+		instance.Spec.SyncTargetSecretName = "new-token"
+	})
+	g.Eventually(secretExists("rename-dst", riggertypes.NewDstSecretName("rename-src", "new-token").String()), timeout).Should(gomega.BeTrue())
+	g.Eventually(secretExists("rename-dst", copyName), timeout).Should(gomega.BeFalse())
+}
+
+func TestReconcileMovesSecretsOnDestinationChange(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	c, stop := setUp(t, g)
+	defer stop()
+
+	createNamespace(g, c, "move-src", "token")
+	createNamespace(g, c, "move-old")
+	createNamespace(g, c, "move-new")
+
+	instance := &riggerv1beta1.ClusterPlan{
+		ObjectMeta: metav1.ObjectMeta{Name: "move"},
+		Spec: riggerv1beta1.PlanSpec{
+			SyncTargetSecretName: "token",
+			SyncDestNamespace:    "move-old",
+			IncludeNamespaces:    []string{"move-src"},
+		},
+	}
+	g.Expect(c.Create(context.TODO(), instance)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), instance)
+
+	copyName := riggertypes.NewDstSecretName("move-src", "token").String()
+	g.Eventually(secretExists("move-old", copyName), timeout).Should(gomega.BeTrue())
+
+	// The copies move to the new destination, which the status records once done.
+	updateClusterPlan(g, c, "move", func(instance *riggerv1beta1.ClusterPlan) {
+		instance.Spec.SyncDestNamespace = "move-new"
+	})
+	g.Eventually(secretExists("move-new", copyName), timeout).Should(gomega.BeTrue())
+	g.Eventually(secretExists("move-old", copyName), timeout).Should(gomega.BeFalse())
+	g.Eventually(func() string {
+		if err := c.Get(context.TODO(), types.NamespacedName{Name: "move"}, instance); err != nil {
+			return ""
+		}
+		return instance.Status.LastSyncDestNamespace
+	}, timeout).Should(gomega.Equal("move-new"))
+}
+
+func TestIsMigratingDestination(t *testing.T) {
+	plan := func(mode riggerv1beta1.PlanMode, dest, lastDest string) *riggerv1beta1.ClusterPlan {
+		return &riggerv1beta1.ClusterPlan{
+			Spec:   riggerv1beta1.PlanSpec{Mode: mode, SyncDestNamespace: dest},
+			Status: riggerv1beta1.PlanStatus{LastSyncDestNamespace: lastDest},
+		}
+	}
+	cases := []struct {
+		name string
+		plan riggerv1beta1.PlanObject
+		want bool
+	}{
+		{name: "not synced yet", plan: plan("", "dst", ""), want: false},
+		{name: "synced", plan: plan("", "dst", "dst"), want: false},
+		{name: "destination changed", plan: plan("", "new", "dst"), want: true},
+		{name: "merged destination changed", plan: plan(riggerv1beta1.PlanModeMerge, "new", "dst"), want: false},
+	}
+	for _, c := range cases {
+		if got := IsMigratingDestination(c.plan); got != c.want {
+			t.Errorf("%s: IsMigratingDestination = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReconcilePrunesSecretsOnIgnoreNamespacesChange(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	c, stop := setUp(t, g)
+	defer stop()
+
+	createNamespace(g, c, "ignore-a", "token")
+	createNamespace(g, c, "ignore-b", "token")
+	createNamespace(g, c, "ignore-dst")
+
+	instance := &riggerv1beta1.ClusterPlan{
+		ObjectMeta: metav1.ObjectMeta{Name: "ignore"},
+		Spec: riggerv1beta1.PlanSpec{
+			SyncTargetSecretName: "token",
+			SyncDestNamespace:    "ignore-dst",
+			IncludeNamespaces:    []string{"ignore-a", "ignore-b"},
+		},
+	}
+	g.Expect(c.Create(context.TODO(), instance)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), instance)
+
+	copyA := riggertypes.NewDstSecretName("ignore-a", "token").String()
+	copyB := riggertypes.NewDstSecretName("ignore-b", "token").String()
+	g.Eventually(secretExists("ignore-dst", copyA), timeout).Should(gomega.BeTrue())
+	g.Eventually(secretExists("ignore-dst", copyB), timeout).Should(gomega.BeTrue())
+
+	// Ignoring a namespace by a pattern prunes its copy, and only its copy.
+	updateClusterPlan(g, c, "ignore", func(instance *riggerv1beta1.ClusterPlan) {
+		instance.Spec.IgnoreNamespaces = []string{"*-b"}
+	})
+	g.Eventually(secretExists("ignore-dst", copyB), timeout).Should(gomega.BeFalse())
+	g.Consistently(secretExists("ignore-dst", copyA), time.Second).Should(gomega.BeTrue())
+
+	// No longer ignoring it syncs the copy again.
+	updateClusterPlan(g, c, "ignore", func(instance *riggerv1beta1.ClusterPlan) {
+		instance.Spec.IgnoreNamespaces = nil
+	})
+	g.Eventually(secretExists("ignore-dst", copyB), timeout).Should(gomega.BeTrue())
+}
+
+func TestReconcileReportsStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	c, stop := setUp(t, g)
+	defer stop()
+
+	createNamespace(g, c, "status-a", "token")
+	createNamespace(g, c, "status-b", "token")
+	createNamespace(g, c, "status-dst")
+
+	instance := &riggerv1beta1.ClusterPlan{
+		ObjectMeta: metav1.ObjectMeta{Name: "status"},
+		Spec: riggerv1beta1.PlanSpec{
+			SyncTargetSecretName: "token",
+			SyncDestNamespace:    "status-dst",
+			IncludeNamespaces:    []string{"status-a", "status-b"},
+		},
+	}
+	g.Expect(c.Create(context.TODO(), instance)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), instance)
+
+	// The status tells the generation synced, the count of the synced secrets and the conditions.
+	key := types.NamespacedName{Name: "status"}
+	g.Eventually(func() int32 {
+		if err := c.Get(context.TODO(), key, instance); err != nil {
+			return 0
+		}
+		return instance.Status.SyncedSecrets
+	}, timeout).Should(gomega.Equal(int32(2)))
+	g.Expect(instance.Status.ObservedGeneration).To(gomega.Equal(instance.Generation))
+	g.Expect(instance.Status.LastSyncTime).NotTo(gomega.BeNil())
+	g.Expect(instance.Status.Failures).To(gomega.BeEmpty())
+	for conditionType, want := range map[riggerv1beta1.PlanConditionType]corev1.ConditionStatus{
+		riggerv1beta1.PlanReady:        corev1.ConditionTrue,
+		riggerv1beta1.PlanSynced:       corev1.ConditionTrue,
+		riggerv1beta1.PlanDegraded:     corev1.ConditionFalse,
+		riggerv1beta1.PlanLoopDetected: corev1.ConditionFalse,
+	} {
+		cond := instance.Status.GetCondition(conditionType)
+		g.Expect(cond).NotTo(gomega.BeNil())
+		g.Expect(cond.Status).To(gomega.Equal(want))
+	}
+}
@@ -0,0 +1,278 @@
+package plan
+
+import (
+	"fmt"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	"github.com/wantedly/rigger/pkg/clientset"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+	"github.com/wantedly/rigger/pkg/util"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// SyncAllNamespaceSecrets syncs the secrets of targets in the namespaces matching filter to destNamespace on behalf of the plan,
+// making them by opts. A namespace failing to sync does not stop syncing the others, and is returned in failures.
+func SyncAllNamespaceSecrets(pl riggerv1beta1.PlanObject, targets []riggerv1beta1.SyncTarget, opts riggertypes.DstSecretOptions, destNamespace string, filter util.NamespaceFilter) (failures []riggerv1beta1.SyncFailure, err error) {
+	namespaces, err := clientset.GetNamespaces()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get namespaces")
+	}
+	for i := range namespaces {
+		if err := SyncNamespaceSecrets(pl, targets, opts, destNamespace, filter, &namespaces[i]); err != nil {
+			log.Error(err, fmt.Sprintf("failed to sync namespace secrets [namespace:%s]", namespaces[i].Name))
+			RecordSyncFailed(pl, namespaces[i].Name, err)
+			failures = append(failures, riggerv1beta1.SyncFailure{Namespace: namespaces[i].Name, Message: err.Error()})
+		}
+	}
+	return failures, nil
+}
+
+// SyncNamespaceSecrets syncs the secrets of targets in srcNamespace to destNamespace on behalf of the plan
+// if filter matches srcNamespace, making them by opts.
+func SyncNamespaceSecrets(pl riggerv1beta1.PlanObject, targets []riggerv1beta1.SyncTarget, opts riggertypes.DstSecretOptions, destNamespace string, filter util.NamespaceFilter, srcNamespace *corev1.Namespace) error {
+	matched, err := filter.Matches(srcNamespace)
+	if err != nil {
+		return errors.Wrapf(err, "failed to match namespace [namespace:%s]", srcNamespace.Name)
+	}
+	if !matched {
+		return nil
+	}
+	srcSecrets := map[string]*corev1.Secret{}
+	for i := range targets {
+		secrets, err := getTargetSecrets(opts.GroupVersionKind, &targets[i], srcNamespace.Name)
+		if err != nil {
+			return err
+		}
+		for j := range secrets {
+			if !util.IsSyncSource(planKey(pl), destNamespace, &secrets[j]) {
+				continue
+			}
+			srcSecrets[secrets[j].Name] = &secrets[j]
+		}
+	}
+	for _, srcSecret := range srcSecrets {
+		// A secret matching several targets is synced once, named after the first target.
+		target, err := util.FindSyncTarget(targets, srcSecret)
+		if err != nil {
+			return errors.Wrapf(err, "failed to match secret [namespace:%s,name:%s]", srcSecret.Namespace, srcSecret.Name)
+		}
+		if err := syncSecret(pl, target, opts, destNamespace, srcSecret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getTargetSecrets returns the objects of gvk in namespace which the target selects, in the shape of secrets.
+func getTargetSecrets(gvk schema.GroupVersionKind, target *riggerv1beta1.SyncTarget, namespace string) ([]corev1.Secret, error) {
+	if target.Selector == nil {
+		secret, err := clientset.Objects(gvk).Get(namespace, target.Name)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to get secret [namespace:%s,name:%s]", namespace, target.Name)
+		}
+		return []corev1.Secret{*secret}, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(target.Selector)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse secret selector")
+	}
+	secrets, err := clientset.Objects(gvk).List(namespace, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	ret := []corev1.Secret{}
+	for _, secret := range secrets {
+		if target.Name == "" || target.Name == secret.Name {
+			ret = append(ret, secret)
+		}
+	}
+	return ret, nil
+}
+
+func syncSecret(pl riggerv1beta1.PlanObject, target *riggerv1beta1.SyncTarget, opts riggertypes.DstSecretOptions, destNamespace string, srcSecret *corev1.Secret) error {
+	plan := planKey(pl)
+	dstName, err := opts.Name(target.DestNamePrefix, srcSecret)
+	if err != nil {
+		return errors.Wrapf(err, "failed to name synced secret [namespace:%s,name:%s]", srcSecret.Namespace, srcSecret.Name)
+	}
+	dstSecret := riggertypes.NewDstSecret(plan, destNamespace, dstName, srcSecret, opts)
+	created, err := clientset.Objects(opts.GroupVersionKind).Create(dstSecret.Namespace, dstSecret)
+	if apierrors.IsAlreadyExists(err) {
+		existing, err := clientset.Objects(opts.GroupVersionKind).Get(dstSecret.Namespace, dstSecret.Name)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+		}
+		// Never overwrite a secret which another plan synced. The secrets synced before the plan labels
+		// were introduced are adopted, which labels them for the plan.
+		if riggertypes.IsDstSecretOfOtherPlan(existing, plan, srcSecret.Namespace, srcSecret.Name) {
+			log.Info(fmt.Sprintf("skipped to overwrite secret synced by another plan [namespace:%s,name:%s,plan:%s]", dstSecret.Namespace, dstSecret.Name, plan))
+			return nil
+		}
+		if riggertypes.IsDstSecretUpToDate(existing, dstSecret) {
+			return nil
+		}
+		// Overwrite the existing Secret.
+		updated, err := clientset.Objects(opts.GroupVersionKind).Update(dstSecret.Namespace, dstSecret)
+		if err != nil {
+			return errors.Wrapf(err, "failed to update secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+		}
+		log.Info(fmt.Sprintf("succeeded to update secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+		RecordSecretUpdated(pl, updated)
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to create secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+	} else {
+		log.Info(fmt.Sprintf("succeeded to create secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+		RecordSecretCreated(pl, created)
+	}
+	return nil
+}
+
+// PruneSyncedSecrets deletes the secrets which the plan synced to destNamespace
+// from namespaces not matching filter, from secrets no longer matching targets, or named other than by opts.
+func PruneSyncedSecrets(pl riggerv1beta1.PlanObject, targets []riggerv1beta1.SyncTarget, opts riggertypes.DstSecretOptions, destNamespace string, filter util.NamespaceFilter) error {
+	plan := planKey(pl)
+	namespaces, err := clientset.GetNamespaces()
+	if err != nil {
+		return errors.Wrap(err, "failed to get namespaces")
+	}
+	srcNamespaces := map[string]bool{}
+	for i := range namespaces {
+		matched, err := filter.Matches(&namespaces[i])
+		if err != nil {
+			return errors.Wrapf(err, "failed to match namespace [namespace:%s]", namespaces[i].Name)
+		}
+		srcNamespaces[namespaces[i].Name] = matched
+	}
+	dstSecrets, err := listSyncedSecrets(plan, opts.GroupVersionKind, destNamespace, riggertypes.NewPlanDstSecretLabels(plan))
+	if err != nil {
+		return err
+	}
+	for _, dstSecret := range dstSecrets {
+		synced, err := isSynced(targets, opts, srcNamespaces, &dstSecret)
+		if err != nil {
+			return err
+		}
+		if synced {
+			continue
+		}
+		err = clientset.Objects(opts.GroupVersionKind).Delete(dstSecret.Namespace, dstSecret.Name, nil)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to delete secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+		}
+		log.Info(fmt.Sprintf("succeeded to delete secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+		RecordSecretPruned(pl, dstSecret.Namespace, dstSecret.Name)
+	}
+	return nil
+}
+
+// isSynced reports whether the destination secret is still the synced secret of its source.
+func isSynced(targets []riggerv1beta1.SyncTarget, opts riggertypes.DstSecretOptions, srcNamespaces map[string]bool, dstSecret *corev1.Secret) (bool, error) {
+	srcNamespace, srcName, ok := riggertypes.GetSrcSecret(dstSecret)
+	if !ok {
+		return false, nil
+	}
+	if !srcNamespaces[srcNamespace] {
+		return false, nil
+	}
+	srcSecret, err := clientset.Objects(opts.GroupVersionKind).Get(srcNamespace, srcName)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrapf(err, "failed to get secret [namespace:%s,name:%s]", srcNamespace, srcName)
+	}
+	target, err := util.FindSyncTarget(targets, srcSecret)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to match secret [namespace:%s,name:%s]", srcNamespace, srcName)
+	}
+	if target == nil {
+		return false, nil
+	}
+	// The name differs if the prefix of the target or the template has been changed.
+	dstName, err := opts.Name(target.DestNamePrefix, srcSecret)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to name synced secret [namespace:%s,name:%s]", srcNamespace, srcName)
+	}
+	return dstName.String() == dstSecret.Name, nil
+}
+
+// CountSyncedSecrets returns the number of the objects of gvk which the plan synced to destNamespace.
+func CountSyncedSecrets(plan types.NamespacedName, gvk schema.GroupVersionKind, destNamespace string) (int, error) {
+	return countSyncedSecrets(destNamespace, plan, gvk)
+}
+
+func countSyncedSecrets(namespace string, plan types.NamespacedName, gvk schema.GroupVersionKind) (int, error) {
+	labels := riggertypes.NewPlanDstSecretLabels(plan)
+	dstSecrets, err := listSyncedSecrets(plan, gvk, namespace, labels)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to list secrets [namespace:%s,selector:%s]", namespace, labels.GetLabelSelector())
+	}
+	return len(dstSecrets), nil
+}
+
+// DeleteSyncedSecret deletes the objects of gvk which the plan synced from the srcName object of srcNamespace from destNamespace.
+func DeleteSyncedSecret(pl riggerv1beta1.PlanObject, gvk schema.GroupVersionKind, destNamespace, srcNamespace, srcName string) error {
+	plan := planKey(pl)
+	return deleteSyncedSecretCollection(pl, gvk, destNamespace, riggertypes.NewDstSecretLabels(plan, srcNamespace, srcName))
+}
+
+// DeleteNamespaceSyncedSecrets deletes the objects of gvk which the plan synced from srcNamespace from destNamespace.
+func DeleteNamespaceSyncedSecrets(pl riggerv1beta1.PlanObject, gvk schema.GroupVersionKind, destNamespace, srcNamespace string) error {
+	plan := planKey(pl)
+	labels := riggertypes.NewPlanDstSecretLabels(plan)
+	labels[riggertypes.DstSecretLabelSrcNamespaceKey] = srcNamespace
+	return deleteSyncedSecretCollection(pl, gvk, destNamespace, labels)
+}
+
+// DeleteSyncedSecrets deletes all the objects of gvk which the plan synced from destNamespace.
+func DeleteSyncedSecrets(pl riggerv1beta1.PlanObject, gvk schema.GroupVersionKind, destNamespace string) error {
+	plan := planKey(pl)
+	return deleteSyncedSecretCollection(pl, gvk, destNamespace, riggertypes.NewPlanDstSecretLabels(plan))
+}
+
+func deleteSyncedSecretCollection(pl riggerv1beta1.PlanObject, gvk schema.GroupVersionKind, destNamespace string, labels riggertypes.DstSecretLabels) error {
+	plan := planKey(pl)
+	labelSelector := labels.GetLabelSelector()
+	// Delete the secrets one by one instead of DeleteCollection to record each of them.
+	dstSecrets, err := listSyncedSecrets(plan, gvk, destNamespace, labels)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list secrets [namespace:%s,selector:%s]", destNamespace, labelSelector)
+	}
+	for _, dstSecret := range dstSecrets {
+		err := clientset.Objects(gvk).Delete(dstSecret.Namespace, dstSecret.Name, nil)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to delete secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name)
+		}
+		log.Info(fmt.Sprintf("succeeded to delete secret [namespace:%s,name:%s]", dstSecret.Namespace, dstSecret.Name))
+		RecordSecretPruned(pl, dstSecret.Namespace, dstSecret.Name)
+	}
+	log.Info(fmt.Sprintf("succeeded to delete secret collection [namespace:%s,selector:%s]", destNamespace, labelSelector))
+	return nil
+}
+
+// listSyncedSecrets returns the objects of gvk in namespace having the labels which the plan synced.
+// The labels hold shortened names, so the secrets synced by another plan of the same labels are left out by the annotations.
+func listSyncedSecrets(plan types.NamespacedName, gvk schema.GroupVersionKind, namespace string, labels riggertypes.DstSecretLabels) ([]corev1.Secret, error) {
+	secrets, err := clientset.Objects(gvk).List(namespace, metav1.ListOptions{LabelSelector: labels.GetLabelSelector()})
+	if err != nil {
+		return nil, err
+	}
+	ret := []corev1.Secret{}
+	for i := range secrets {
+		if riggertypes.IsDstSecretSyncedBy(&secrets[i], plan) {
+			ret = append(ret, secrets[i])
+		}
+	}
+	return ret, nil
+}
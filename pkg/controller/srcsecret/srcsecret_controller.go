@@ -3,7 +3,9 @@ package srcsecret
 import (
 	"context"
 	"fmt"
-	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
 	"github.com/wantedly/rigger/pkg/clientset"
@@ -13,10 +15,14 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -26,27 +32,49 @@ import (
 
 var log = logf.Log.WithName("src-secret-controller")
 
-// Add creates a new Secret Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
-// and Start it when the Manager is Started.
+// Add creates a new Controller for each kind to sync, such as Secret and ConfigMap, and adds them to the Manager with default RBAC.
+// The Manager will set fields on the Controllers and Start them when the Manager is Started.
 func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+	for _, gvk := range clientset.SyncKinds() {
+		name := fmt.Sprintf("src-%s-controller", strings.ToLower(gvk.GroupKind().String()))
+		if err := add(mgr, name, util.NewSyncObject(gvk), newReconciler(mgr, gvk)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileSrcSecret{Client: mgr.GetClient(), scheme: mgr.GetScheme()}
+// newReconciler returns a new reconcile.Reconciler of the objects of gvk
+func newReconciler(mgr manager.Manager, gvk schema.GroupVersionKind) *ReconcileSrcSecret {
+	return &ReconcileSrcSecret{Client: mgr.GetClient(), scheme: mgr.GetScheme(), gvk: gvk, started: time.Now(), changed: map[types.NamespacedName]time.Time{}}
 }
 
-// add adds a new Controller to mgr with r as the reconcile.Reconciler
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
+// add adds a new Controller named name to mgr with r as the reconcile.Reconciler of the objects of the type of obj
+func add(mgr manager.Manager, name string, obj runtime.Object, r *ReconcileSrcSecret) error {
 	// Create a new controller
-	c, err := controller.New("src-secret-controller", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New(name, mgr, controller.Options{Reconciler: r})
 	if err != nil {
 		return err
 	}
 
-	// Watch for changes to Secret
-	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{})
+	// Watch for changes to the objects to sync, remembering when they changed
+	enqueue := &handler.EnqueueRequestForObject{}
+	err = c.Watch(&source.Kind{Type: obj}, handler.Funcs{
+		CreateFunc: func(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			if e.Meta != nil && e.Meta.GetCreationTimestamp().Time.After(r.started) {
+				r.rememberChanged(e.Meta, e.Meta.GetCreationTimestamp().Time)
+			}
+			enqueue.Create(e, q)
+		},
+		UpdateFunc: func(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			if e.MetaNew != nil {
+				r.rememberChanged(e.MetaNew, time.Now())
+			}
+			enqueue.Update(e, q)
+		},
+		DeleteFunc:  enqueue.Delete,
+		GenericFunc: enqueue.Generic,
+	})
 	if err != nil {
 		return err
 	}
@@ -56,21 +84,60 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 
 var _ reconcile.Reconciler = &ReconcileSrcSecret{}
 
-// ReconcileSecret reconciles a Secret object
+// ReconcileSecret reconciles a Secret object, or an object of another kind in the shape of a Secret
 type ReconcileSrcSecret struct {
 	client.Client
 	scheme *runtime.Scheme
+	// gvk is the kind of the objects reconciled, only the plans of which are followed.
+	gvk schema.GroupVersionKind
+
+	// changed holds when the objects changed until they are reconciled, from which the sync latency is measured.
+	// The objects listed on start are not changes, and the updates are timed as they are watched
+	// since the objects do not record when they were updated.
+	started   time.Time
+	changedMu sync.Mutex
+	changed   map[types.NamespacedName]time.Time
+}
+
+// rememberChanged remembers that the object changed at the time unless an earlier change is still pending.
+func (r *ReconcileSrcSecret) rememberChanged(meta metav1.Object, at time.Time) {
+	key := types.NamespacedName{Namespace: meta.GetNamespace(), Name: meta.GetName()}
+	r.changedMu.Lock()
+	defer r.changedMu.Unlock()
+	if pending, ok := r.changed[key]; ok && pending.Before(at) {
+		return
+	}
+	r.changed[key] = at
+}
+
+// forgetChanged returns when the object of key changed, and forgets it.
+func (r *ReconcileSrcSecret) forgetChanged(key types.NamespacedName) (time.Time, bool) {
+	r.changedMu.Lock()
+	defer r.changedMu.Unlock()
+	at, ok := r.changed[key]
+	delete(r.changed, key)
+	return at, ok
+}
+
+// observeSyncLatency records the time since the source changed once a synced secret has been written.
+func observeSyncLatency(changed time.Time, ok bool) {
+	if ok {
+		planctrl.ObserveSyncLatency(changed)
+	}
 }
 
 // Reconcile reads that state of the cluster for a Secret object and makes changes based on the state read
 // and what is in the Secret.Spec
-// Automatically generate RBAC rules to allow the Controller to read and write Secrets
+// Automatically generate RBAC rules to allow the Controller to read and write Secrets and ConfigMaps
 // +kubebuilder:rbac:groups=cores,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 func (r *ReconcileSrcSecret) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	changed, changedOK := r.forgetChanged(request.NamespacedName)
+
 	// Fetch the Secret instance
-	srcSecret, srcSecretDeleted, err := util.ReconcilesFetchSecret(r, context.TODO(), request.NamespacedName)
+	srcSecret, srcSecretDeleted, err := util.ReconcilesFetchObject(r, context.TODO(), r.gvk, request.NamespacedName)
 	if err != nil {
-		return reconcile.Result{}, fmt.Errorf("failed to get secret %s", request.NamespacedName)
+		return reconcile.Result{}, fmt.Errorf("failed to get %s %s", r.gvk, request.NamespacedName)
 	}
 	srcSecretExists := !srcSecretDeleted
 
@@ -78,66 +145,228 @@ func (r *ReconcileSrcSecret) Reconcile(request reconcile.Request) (reconcile.Res
 	srcSecretNamespace := request.NamespacedName.Namespace
 	srcSecretName := request.NamespacedName.Name
 
+	// Fetch the Namespace of the Secret to verify that the Namespace is sync source.
+	namespace, namespaceDeleted, err := util.ReconcilesFetchNamespace(r, context.TODO(), srcSecretNamespace)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get namespace %s", srcSecretNamespace)
+	}
+
 	// If the Secret is sync target, sync the Secret to the destination.
-	planctrl.Cache.Range(func(name, plan interface{}) bool {
+	migrating := false
+	err = planctrl.Cache.Range(func(pl riggerv1beta1.PlanObject) bool {
+		if pl.GetSpec().GetGroupVersionKind() != r.gvk {
+			return true // continue
+		}
+		planKey := types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}
+		dstNamespace := pl.GetSpec().SyncDestNamespace
+		if pl.GetSpec().GetMode() == riggerv1beta1.PlanModeDistribute {
+			source := pl.GetSpec().Source
+			if source == nil || source.Namespace != srcSecretNamespace || source.Name != srcSecretName {
+				return true // continue
+			}
+			written, err := r.distribute(pl, srcSecret, srcSecretExists)
+			if err != nil {
+				log.Error(err, fmt.Sprintf("failed to distribute secret [namespace:%s,name:%s,plan:%s]", srcSecretNamespace, srcSecretName, planKey))
+				planctrl.RecordSyncFailed(pl, srcSecretNamespace, err)
+			} else if written {
+				observeSyncLatency(changed, changedOK)
+			}
+			return true // continue
+		}
+		if pl.GetSpec().GetMode() == riggerv1beta1.PlanModeMerge {
+			merged, err := r.merge(pl, request.NamespacedName, srcSecret, srcSecretExists && !namespaceDeleted, namespace)
+			if err != nil {
+				log.Error(err, fmt.Sprintf("failed to merge secret [namespace:%s,name:%s,plan:%s]", srcSecretNamespace, srcSecretName, planKey))
+				planctrl.RecordSyncFailed(pl, srcSecretNamespace, err)
+			} else if merged {
+				observeSyncLatency(changed, changedOK)
+			}
+			return true // continue
+		}
+
+		if planctrl.IsMigratingDestination(pl) {
+			// Retry once the plan-controller has moved the synced secrets to the new destination.
+			migrating = true
+			return true // continue
+		}
+
 		// Verify that the Secret is sync target.
-		pl := plan.(*riggerv1beta1.Plan)
-		if srcSecretName != pl.Spec.SyncTargetSecretName || util.Contains(srcSecretNamespace, pl.Spec.IgnoreNamespaces) {
+		// If the Secret or the Namespace has been deleted, delete the synced secret regardless of the Plan.
+		var target *riggerv1beta1.SyncTarget
+		if srcSecretExists && !namespaceDeleted {
+			var err error
+			target, err = util.FindSyncTarget(pl.GetSpec().GetSyncTargets(), srcSecret)
+			if err != nil {
+				log.Error(err, fmt.Sprintf("failed to match secret [namespace:%s,name:%s,plan:%s]", srcSecretNamespace, srcSecretName, planKey))
+				return true // continue
+			}
+			if target != nil {
+				matched, err := util.NewNamespaceFilter(pl).Matches(namespace)
+				if err != nil {
+					log.Error(err, fmt.Sprintf("failed to match namespace [namespace:%s]", srcSecretNamespace))
+					return true // continue
+				}
+				if !matched {
+					target = nil
+				}
+			}
+			if target != nil && !util.IsSyncSource(planKey, dstNamespace, srcSecret) {
+				target = nil
+			}
+		}
+		if target == nil {
+			// The Secret may have been sync target before its labels changed.
+			if err := r.deleteSyncedSecret(pl, dstNamespace, srcSecretNamespace, srcSecretName); err != nil {
+				log.Error(err, fmt.Sprintf("failed to delete synced secret [namespace:%s,name:%s,plan:%s]", srcSecretNamespace, srcSecretName, planKey))
+			}
 			return true // continue
 		}
 
 		// Following is operation for sync target.
 
-		dstNamespace := pl.Spec.SyncDestNamespace
-		dstName := riggertypes.NewDstSecretName(srcSecretNamespace, srcSecretName)
-		dstSecret, dstSecretNotFound, err := util.ReconcilesFetchSecret(r, context.TODO(), types.NamespacedName{Namespace: dstNamespace, Name: dstName.String()})
+		opts := riggertypes.NewDstSecretOptions(pl.GetSpec())
+		dstName, err := opts.Name(target.DestNamePrefix, srcSecret)
+		if err != nil {
+			log.Error(err, fmt.Sprintf("failed to name synced secret [namespace:%s,name:%s,plan:%s]", srcSecretNamespace, srcSecretName, planKey))
+			planctrl.RecordSyncFailed(pl, srcSecretNamespace, err)
+			return true // continue
+		}
+		dstSecret, dstSecretNotFound, err := util.ReconcilesFetchObject(r, context.TODO(), r.gvk, types.NamespacedName{Namespace: dstNamespace, Name: dstName.String()})
 		if err != nil {
 			log.Error(err, fmt.Sprintf("failed to get secret %s/%s", dstNamespace, dstName))
 			return true // continue
 		}
 		dstSecretExists := !dstSecretNotFound
+		ds := riggertypes.NewDstSecret(planKey, dstNamespace, dstName, srcSecret, opts)
 
 		switch {
-		case srcSecretExists && dstSecretNotFound:
+		case dstSecretNotFound:
 			// Create destination Secret
-			ds := riggertypes.NewDstSecret(dstNamespace, dstName, srcSecret)
-			_, err := clientset.CreateSecret(dstNamespace, ds)
+			created, err := clientset.Objects(r.gvk).Create(dstNamespace, ds)
 			if apierrors.IsAlreadyExists(err) {
 				log.Info(fmt.Sprintf("tried to create a secret, but it already exists [namespace:%s,name:%s]", dstNamespace, dstName))
 			} else if err != nil {
 				log.Error(err, fmt.Sprintf("failed to create secret [namespace:%s,name:%s]", dstNamespace, dstName))
+				planctrl.RecordSyncFailed(pl, srcSecretNamespace, err)
 				return true // continue
 			} else {
 				log.Info(fmt.Sprintf("succeeded to create secret [namespace:%s,name:%s]", dstNamespace, dstName))
+				planctrl.RecordSecretCreated(pl, created)
+				observeSyncLatency(changed, changedOK)
+			}
+		case dstSecretExists:
+			// Update destination Secret unless another plan synced it.
+			if riggertypes.IsDstSecretOfOtherPlan(dstSecret, planKey, srcSecretNamespace, srcSecretName) {
+				log.Info(fmt.Sprintf("skipped to overwrite secret synced by another plan [namespace:%s,name:%s,plan:%s]", dstNamespace, dstName, planKey))
+				return true // continue
 			}
-		case srcSecretExists && dstSecretExists:
-			// Update destination Secret
-			if reflect.DeepEqual(srcSecret.Data, dstSecret.Data) {
+			if riggertypes.IsDstSecretUpToDate(dstSecret, ds) {
 				return true // continue
 			}
-			ds := riggertypes.NewDstSecret(dstNamespace, dstName, srcSecret)
-			_, err := clientset.UpdateSecret(dstNamespace, ds)
+			updated, err := clientset.Objects(r.gvk).Update(dstNamespace, ds)
 			if apierrors.IsNotFound(err) {
 				log.Info(fmt.Sprintf("tried to update a secret, but it not found [namespace:%s,name:%s]", dstNamespace, dstName))
 			} else if err != nil {
 				log.Error(err, fmt.Sprintf("failed to update secret [namespace:%s,name:%s]", dstNamespace, dstName))
+				planctrl.RecordSyncFailed(pl, srcSecretNamespace, err)
 				return true // continue
 			} else {
 				log.Info(fmt.Sprintf("succeeded to update secret [namespace:%s,name:%s]", dstNamespace, dstName))
-			}
-		case srcSecretDeleted:
-			// Delete destination Secret
-			err := clientset.DeleteSecret(dstNamespace, dstName.String(), nil)
-			if apierrors.IsNotFound(err) {
-				log.Info(fmt.Sprintf("tried to delete a secret, but it not found [namespace:%s,name:%s]", dstNamespace, dstName))
-			} else if err != nil {
-				log.Error(err, fmt.Sprintf("failed to delete secret [namespace:%s,name:%s]", dstNamespace, dstName))
-			} else {
-				log.Info(fmt.Sprintf("succeeded to delete secret [namespace:%s,name:%s]", dstNamespace, dstName))
+				planctrl.RecordSecretUpdated(pl, updated)
+				observeSyncLatency(changed, changedOK)
 			}
 		}
 		return true // continue
 	})
+	if err != nil {
+		return reconcile.Result{}, err
+	}
 
+	if migrating {
+		return reconcile.Result{Requeue: true}, nil
+	}
 	return reconcile.Result{}, nil
 }
+
+// deleteSyncedSecret deletes the secrets which the plan synced from the srcName secret of srcNamespace
+// if the informer cache holds any of them.
+func (r *ReconcileSrcSecret) deleteSyncedSecret(pl riggerv1beta1.PlanObject, dstNamespace, srcNamespace, srcName string) error {
+	planKey := types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}
+	labels := riggertypes.NewDstSecretLabels(planKey, srcNamespace, srcName)
+	dstSecrets, err := util.ReconcilesListObjects(r, context.TODO(), r.gvk, client.InNamespace(dstNamespace).MatchingLabels(labels))
+	if err != nil {
+		return err
+	}
+	if len(dstSecrets) == 0 {
+		return nil
+	}
+	return planctrl.DeleteSyncedSecret(pl, r.gvk, dstNamespace, srcNamespace, srcName)
+}
+
+// distribute syncs the source secret of the plan in Distribute mode to the selected namespaces,
+// or deletes the synced secrets if the source secret has been deleted. written reports whether any synced secret has been created or updated.
+func (r *ReconcileSrcSecret) distribute(pl riggerv1beta1.PlanObject, srcSecret *corev1.Secret, srcSecretExists bool) (written bool, err error) {
+	planKey := types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}
+	if !srcSecretExists {
+		return false, planctrl.DeleteDistributedSecrets(pl, r.gvk)
+	}
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(context.TODO(), &client.ListOptions{}, namespaces); err != nil {
+		return false, err
+	}
+	filter := util.NewNamespaceFilter(pl)
+	opts := riggertypes.NewDstSecretOptions(pl.GetSpec())
+	for i := range namespaces.Items {
+		// Look up the synced secret in the informer cache to avoid needless writes.
+		dstSecret, dstSecretNotFound, err := util.ReconcilesFetchObject(r, context.TODO(), r.gvk, types.NamespacedName{Namespace: namespaces.Items[i].Name, Name: srcSecret.Name})
+		if err != nil {
+			return written, err
+		}
+		if !dstSecretNotFound && riggertypes.IsDstSecretUpToDate(dstSecret, riggertypes.NewDstSecret(planKey, dstSecret.Namespace, riggertypes.DstSecretName(srcSecret.Name), srcSecret, opts)) {
+			continue
+		}
+		w, err := planctrl.DistributeNamespaceSecret(pl, srcSecret, opts, filter, &namespaces.Items[i])
+		if err != nil {
+			return written, err
+		}
+		written = written || w
+	}
+	return written, nil
+}
+
+// merge rebuilds the secret into which the plan in Merge mode merges the target secrets
+// if the secret of key is or was one of them. merged reports whether the secret has been written.
+func (r *ReconcileSrcSecret) merge(pl riggerv1beta1.PlanObject, key types.NamespacedName, srcSecret *corev1.Secret, srcSecretExists bool, namespace *corev1.Namespace) (merged bool, err error) {
+	planKey := types.NamespacedName{Namespace: pl.GetNamespace(), Name: pl.GetName()}
+	isSource := false
+	if srcSecretExists {
+		target, err := util.FindSyncTarget(pl.GetSpec().GetSyncTargets(), srcSecret)
+		if err != nil {
+			return false, err
+		}
+		if target != nil && util.IsSyncSource(planKey, pl.GetSpec().SyncDestNamespace, srcSecret) {
+			isSource, err = util.NewNamespaceFilter(pl).Matches(namespace)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	if !isSource {
+		// The secret may have been merged before it was deleted or its labels changed.
+		sources, err := planctrl.GetMergedSources(r, pl)
+		if err != nil {
+			return false, err
+		}
+		if !sources.Has(key) {
+			return false, nil
+		}
+	}
+	merged, err = planctrl.MergeSecrets(r, pl)
+	if e := planctrl.UpdateRenderedCondition(r, pl, err); e != nil {
+		log.Error(e, fmt.Sprintf("failed to report rendering of merged secret [plan:%s]", planKey))
+	}
+	if err != nil {
+		return false, err
+	}
+	return merged, nil
+}
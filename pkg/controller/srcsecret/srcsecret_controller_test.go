@@ -0,0 +1,26 @@
+package srcsecret
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRememberChanged(t *testing.T) {
+	r := &ReconcileSrcSecret{changed: map[types.NamespacedName]time.Time{}}
+	meta := &metav1.ObjectMeta{Namespace: "team-a", Name: "token"}
+	key := types.NamespacedName{Namespace: "team-a", Name: "token"}
+	first := time.Now()
+
+	// The latency is measured from the earliest change not yet reconciled.
+	r.rememberChanged(meta, first)
+	r.rememberChanged(meta, first.Add(time.Second))
+	if got, ok := r.forgetChanged(key); !ok || !got.Equal(first) {
+		t.Errorf("forgetChanged = %v, %v, want %v, true", got, ok, first)
+	}
+	if _, ok := r.forgetChanged(key); ok {
+		t.Errorf("forgetChanged = _, true after forgotten, want false")
+	}
+}
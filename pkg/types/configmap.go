@@ -0,0 +1,55 @@
+package types
+
+import (
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The sync pipeline handles a ConfigMap in the shape of an Opaque Secret, whose data holds both Data and BinaryData
+// of the ConfigMap. Such a Secret keeps the TypeMeta of the ConfigMap, so that the events refer to the ConfigMap.
+
+// SecretFromConfigMap returns the Secret in whose shape the ConfigMap is synced.
+func SecretFromConfigMap(cm *corev1.ConfigMap) *corev1.Secret {
+	data := make(map[string][]byte, len(cm.Data)+len(cm.BinaryData))
+	for k, v := range cm.Data {
+		data[k] = []byte(v)
+	}
+	for k, v := range cm.BinaryData {
+		data[k] = v
+	}
+	return &corev1.Secret{
+		TypeMeta:   configMapTypeMeta(),
+		ObjectMeta: cm.ObjectMeta,
+		Type:       corev1.SecretTypeOpaque,
+		Data:       data,
+	}
+}
+
+// ConfigMapFromSecret returns the ConfigMap in the shape of the Secret, see SecretFromConfigMap.
+// Values of valid UTF-8 go to Data, and the others go to BinaryData.
+func ConfigMapFromSecret(secret *corev1.Secret) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		TypeMeta:   configMapTypeMeta(),
+		ObjectMeta: secret.ObjectMeta,
+	}
+	for k, v := range secret.Data {
+		if utf8.Valid(v) {
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
+			}
+			cm.Data[k] = string(v)
+			continue
+		}
+		if cm.BinaryData == nil {
+			cm.BinaryData = map[string][]byte{}
+		}
+		cm.BinaryData[k] = v
+	}
+	return cm
+}
+
+func configMapTypeMeta() metav1.TypeMeta {
+	return metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+}
@@ -0,0 +1,314 @@
+package types
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"text/template"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// MaxSecretSize is the maximum total size of a secret which the API server accepts.
+const MaxSecretSize = 1024 * 1024
+
+// DstSecretAnnotationMergedSourcesKey is the annotation holding the sources of a merged secret
+// as comma separated "namespace/name" entries.
+const DstSecretAnnotationMergedSourcesKey = "rigger.k8s.wantedly.com/merged-sources"
+
+// maxMergedSourcesSize bounds DstSecretAnnotationMergedSourcesKey well within the 256KiB which the API server
+// accepts for all the annotations of an object.
+const maxMergedSourcesSize = 64 * 1024
+
+// mergedSourcesIndexPrefix prefixes DstSecretAnnotationMergedSourcesKey holding a bloom filter of the sources
+// in base64, in place of the sources which are too many to be listed within maxMergedSourcesSize.
+const mergedSourcesIndexPrefix = "bloom:"
+
+// mergedSourcesIndexSize is the size of the bloom filter in bytes, which is within maxMergedSourcesSize in base64.
+// It keeps false positives below 5% up to 20,000 sources, which only cost needless rebuilds of the merged secret.
+const mergedSourcesIndexSize = 32 * 1024
+
+// mergedSourcesIndexHashes is the number of the bits set in the bloom filter for each entry.
+const mergedSourcesIndexHashes = 4
+
+// NewMergedSecret returns the secret into which the plan merges srcSecrets. Each key is prefixed with the namespace
+// of its source such as "<namespace>.<key>", after the keys are selected and renamed by opts.
+// Sources are merged in the order of their namespaces and names, and the keys which an earlier source already gave
+// or which are invalid are skipped and described in skipped. If opts has Template, the data is rendered from the sources instead,
+// and a *TemplateError is returned if it fails. It returns an error if the merged secret exceeds MaxSecretSize,
+// counting the keys, the labels and the annotations as well as the data.
+func NewMergedSecret(plan apitypes.NamespacedName, dstNamespace, dstName string, srcSecrets []*corev1.Secret, opts DstSecretOptions) (dstSecret *corev1.Secret, skipped []string, err error) {
+	sorted := make([]*corev1.Secret, len(srcSecrets))
+	copy(sorted, srcSecrets)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Namespace != sorted[j].Namespace {
+			return sorted[i].Namespace < sorted[j].Namespace
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	var data map[string][]byte
+	secretType := corev1.SecretTypeOpaque
+	if opts.Template != nil {
+		data, err = renderTemplate(opts.Template, sorted, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if opts.Template.Type != "" {
+			secretType = opts.Template.Type
+		}
+	} else {
+		data, skipped = mergeData(sorted, opts)
+	}
+
+	labels := propagateMetadata(opts.Labels, nil)
+	annotations := propagateMetadata(opts.Annotations, nil)
+	setPropagatedKeys(annotations, labels)
+	for k, v := range NewPlanDstSecretLabels(plan) {
+		labels[k] = v
+	}
+	annotations[DstSecretAnnotationPlanNamespaceKey] = plan.Namespace
+	annotations[DstSecretAnnotationPlanNameKey] = plan.Name
+	annotations[DstSecretAnnotationMergedSourcesKey] = newMergedSourcesAnnotation(sorted)
+
+	size := 0
+	for k, v := range data {
+		size += len(k) + len(v)
+	}
+	for _, m := range []map[string]string{labels, annotations} {
+		for k, v := range m {
+			size += len(k) + len(v)
+		}
+	}
+	if size > MaxSecretSize {
+		return nil, skipped, fmt.Errorf("merged secret of %d bytes exceeds the maximum secret size %d bytes", size, MaxSecretSize)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   dstNamespace,
+			Name:        dstName,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Type: secretType,
+		Data: data,
+	}, skipped, nil
+}
+
+// mergeData prefixes the keys of the sorted srcSecrets with their namespaces, skipping the keys already given.
+func mergeData(srcSecrets []*corev1.Secret, opts DstSecretOptions) (data map[string][]byte, skipped []string) {
+	data = map[string][]byte{}
+	for _, srcSecret := range srcSecrets {
+		srcData := transformData(opts.Keys, opts.KeyMappings, srcSecret.Data)
+		keys := make([]string, 0, len(srcData))
+		for k := range srcData {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var skippedKeys []string
+		for _, k := range keys {
+			key := srcSecret.Namespace + DstSecretNameSep + k
+			if _, ok := data[key]; ok || len(validation.IsConfigMapKey(key)) > 0 {
+				skippedKeys = append(skippedKeys, k)
+				continue
+			}
+			data[key] = srcData[k]
+		}
+		if len(skippedKeys) > 0 {
+			skipped = append(skipped, fmt.Sprintf("keys %s of secret %s/%s", strings.Join(skippedKeys, ","), srcSecret.Namespace, srcSecret.Name))
+		}
+	}
+	return data, skipped
+}
+
+// TemplateError is the error of rendering the key of a merged secret from its template.
+type TemplateError struct {
+	Key string
+	Err error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("failed to render template of key %q: %v", e.Key, e.Err)
+}
+
+// TemplateSource is a source secret given to the templates of a merged secret.
+type TemplateSource struct {
+	Namespace   string
+	Name        string
+	Labels      map[string]string
+	Annotations map[string]string
+	Data        map[string]string
+}
+
+// TemplateFuncs are the functions available to the templates of a merged secret.
+var TemplateFuncs = template.FuncMap{
+	"b64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"b64dec": func(s string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		return string(b), err
+	},
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// ParseTemplate parses the template of the key of a merged secret.
+func ParseTemplate(key, text string) (*template.Template, error) {
+	return template.New(key).Funcs(TemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+func renderTemplate(tmpl *riggerv1beta1.SecretTemplate, srcSecrets []*corev1.Secret, opts DstSecretOptions) (map[string][]byte, error) {
+	sources := make([]TemplateSource, 0, len(srcSecrets))
+	for _, srcSecret := range srcSecrets {
+		data := map[string]string{}
+		for k, v := range transformData(opts.Keys, opts.KeyMappings, srcSecret.Data) {
+			data[k] = string(v)
+		}
+		sources = append(sources, TemplateSource{
+			Namespace:   srcSecret.Namespace,
+			Name:        srcSecret.Name,
+			Labels:      srcSecret.Labels,
+			Annotations: srcSecret.Annotations,
+			Data:        data,
+		})
+	}
+	keys := make([]string, 0, len(tmpl.Data))
+	for key := range tmpl.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ret := map[string][]byte{}
+	for _, key := range keys {
+		t, err := ParseTemplate(key, tmpl.Data[key])
+		if err != nil {
+			return nil, &TemplateError{Key: key, Err: err}
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, struct{ Sources []TemplateSource }{sources}); err != nil {
+			return nil, &TemplateError{Key: key, Err: err}
+		}
+		ret[key] = buf.Bytes()
+	}
+	return ret, nil
+}
+
+// newMergedSourcesAnnotation returns the value of DstSecretAnnotationMergedSourcesKey for the sorted srcSecrets,
+// which lists them as "namespace/name" entries, or holds a bloom filter of them if the list is too long.
+func newMergedSourcesAnnotation(srcSecrets []*corev1.Secret) string {
+	sources := make([]string, 0, len(srcSecrets))
+	for _, srcSecret := range srcSecrets {
+		sources = append(sources, srcSecret.Namespace+"/"+srcSecret.Name)
+	}
+	if value := strings.Join(sources, ","); len(value) <= maxMergedSourcesSize {
+		return value
+	}
+	index := make([]byte, mergedSourcesIndexSize)
+	for _, srcSecret := range srcSecrets {
+		addToIndex(index, mergedSourceNamespaceKey(srcSecret.Namespace))
+		addToIndex(index, mergedSourceKey(apitypes.NamespacedName{Namespace: srcSecret.Namespace, Name: srcSecret.Name}))
+	}
+	return mergedSourcesIndexPrefix + base64.StdEncoding.EncodeToString(index)
+}
+
+func mergedSourceKey(source apitypes.NamespacedName) string {
+	return source.Namespace + "/" + source.Name
+}
+
+// mergedSourceNamespaceKey is the entry of the bloom filter for the namespace, which cannot be the key of any source.
+func mergedSourceNamespaceKey(namespace string) string {
+	return namespace + "/"
+}
+
+// indexBits returns the bits of the bloom filter of the size for the key, by double hashing.
+func indexBits(key string, size int) []uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	bits := make([]uint64, mergedSourcesIndexHashes)
+	for i := range bits {
+		bits[i] = (h1 + uint64(i)*h2) % uint64(size*8)
+	}
+	return bits
+}
+
+func addToIndex(index []byte, key string) {
+	for _, bit := range indexBits(key, len(index)) {
+		index[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func indexContains(index []byte, key string) bool {
+	for _, bit := range indexBits(key, len(index)) {
+		if index[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MergedSources tells the sources of a merged secret, see GetMergedSources.
+// If the sources were too many to be listed, it may report a secret or a namespace which was not merged,
+// but never misses one which was.
+type MergedSources struct {
+	sources map[apitypes.NamespacedName]bool
+	index   []byte
+}
+
+// Has reports whether the source has been merged.
+func (s MergedSources) Has(source apitypes.NamespacedName) bool {
+	if s.index != nil {
+		return indexContains(s.index, mergedSourceKey(source))
+	}
+	return s.sources[source]
+}
+
+// HasNamespace reports whether any source of the namespace has been merged.
+func (s MergedSources) HasNamespace(namespace string) bool {
+	if s.index != nil {
+		return indexContains(s.index, mergedSourceNamespaceKey(namespace))
+	}
+	for source := range s.sources {
+		if source.Namespace == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMergedSources returns the sources of the merged secret.
+func GetMergedSources(mergedSecret *corev1.Secret) MergedSources {
+	value := mergedSecret.Annotations[DstSecretAnnotationMergedSourcesKey]
+	if strings.HasPrefix(value, mergedSourcesIndexPrefix) {
+		index, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, mergedSourcesIndexPrefix))
+		if err == nil && len(index) > 0 {
+			return MergedSources{index: index}
+		}
+		// A broken index tells nothing, so any secret may have been merged as the index full of bits says.
+		return MergedSources{index: []byte{0xff}}
+	}
+	sources := map[apitypes.NamespacedName]bool{}
+	if value == "" {
+		return MergedSources{sources: sources}
+	}
+	for _, source := range strings.Split(value, ",") {
+		parts := strings.SplitN(source, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		sources[apitypes.NamespacedName{Namespace: parts[0], Name: parts[1]}] = true
+	}
+	return MergedSources{sources: sources}
+}
@@ -0,0 +1,128 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+)
+
+// SecretGroupVersionKind and ConfigMapGroupVersionKind are the kinds which rigger syncs without configuration.
+var (
+	SecretGroupVersionKind    = corev1.SchemeGroupVersion.WithKind("Secret")
+	ConfigMapGroupVersionKind = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+)
+
+// The sync pipeline handles an object of any other kind in the shape of an Opaque Secret, whose data holds each
+// top-level field of the object but apiVersion, kind and metadata as JSON, such as "spec" and "status".
+// Such a Secret keeps the TypeMeta of the object, so that the events refer to the object.
+
+// SecretFromUnstructured returns the Secret in whose shape the object is synced.
+func SecretFromUnstructured(u *unstructured.Unstructured) (*corev1.Secret, error) {
+	fields := make(map[string]interface{}, len(u.Object))
+	for k, v := range u.Object {
+		if isMetadataField(k) {
+			continue
+		}
+		fields[k] = v
+	}
+	data, err := encodeFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	secret := &corev1.Secret{Type: corev1.SecretTypeOpaque, Data: data}
+	if metadata, ok := u.Object["metadata"].(map[string]interface{}); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(metadata, &secret.ObjectMeta); err != nil {
+			return nil, err
+		}
+	}
+	secret.APIVersion, secret.Kind = u.GroupVersionKind().ToAPIVersionAndKind()
+	return secret, nil
+}
+
+// UnstructuredFromSecret returns the object of gvk in the shape of the Secret, see SecretFromUnstructured.
+func UnstructuredFromSecret(secret *corev1.Secret, gvk schema.GroupVersionKind) (*unstructured.Unstructured, error) {
+	fields, err := decodeFields(secret.Data)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&secret.ObjectMeta)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: fields}
+	u.Object["metadata"] = metadata
+	u.SetGroupVersionKind(gvk)
+	return u, nil
+}
+
+// isMetadataField reports whether the top-level field belongs to the identity of an object rather than its contents.
+func isMetadataField(field string) bool {
+	return field == "apiVersion" || field == "kind" || field == "metadata"
+}
+
+// selectFields returns the fields of the data of an object in the shape of a Secret selected by the rule.
+// Paths which do not exist are ignored. Data which does not hold fields is returned as is.
+func selectFields(rule *riggerv1beta1.FieldRule, data map[string][]byte) map[string][]byte {
+	if rule == nil || len(rule.Include) == 0 && len(rule.Exclude) == 0 {
+		return data
+	}
+	fields, err := decodeFields(data)
+	if err != nil {
+		return data
+	}
+	if len(rule.Include) > 0 {
+		included := map[string]interface{}{}
+		for _, p := range rule.Include {
+			path := strings.Split(p, ".")
+			if v, ok, err := unstructured.NestedFieldNoCopy(fields, path...); err == nil && ok {
+				// The path has been traversed through objects, so it can be set.
+				_ = unstructured.SetNestedField(included, v, path...)
+			}
+		}
+		fields = included
+	}
+	for _, p := range rule.Exclude {
+		unstructured.RemoveNestedField(fields, strings.Split(p, ".")...)
+	}
+	ret, err := encodeFields(fields)
+	if err != nil {
+		return data
+	}
+	return ret
+}
+
+// decodeFields returns the fields held by the data, keeping integers as int64 as unstructured objects do.
+func decodeFields(data map[string][]byte) (map[string]interface{}, error) {
+	raw := make(map[string]json.RawMessage, len(data))
+	for k, v := range data {
+		raw[k] = v
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := utiljson.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func encodeFields(fields map[string]interface{}) (map[string][]byte, error) {
+	data := make(map[string][]byte, len(fields))
+	for k, v := range fields {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data[k] = b
+	}
+	return data, nil
+}
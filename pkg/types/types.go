@@ -1,61 +1,398 @@
 package types
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"reflect"
+	"sort"
 	"strings"
+	"text/template"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 type DstSecretName string
 
 const DstSecretNameSep = "."
 
+// maxDstSecretNameLength is the maximum length of a secret name.
+const maxDstSecretNameLength = 253
+
+// maxLabelValueLength is the maximum length of a label value.
+const maxLabelValueLength = 63
+
+// NewDstSecretName returns the destination name of the source secret.
+// The name is only for humans, see GetSrcSecret to find the source of a destination secret.
 func NewDstSecretName(srcSecretNamespace, srcSecretName string) DstSecretName {
-	return DstSecretName(srcSecretNamespace + DstSecretNameSep + srcSecretName)
+	return NewPrefixedDstSecretName("", srcSecretNamespace, srcSecretName)
+}
+
+// NewPrefixedDstSecretName returns the destination name of the source secret prefixed with prefix.
+// A name longer than a secret name can be is shortened with a hash of the whole name, so that it stays unique.
+func NewPrefixedDstSecretName(prefix, srcSecretNamespace, srcSecretName string) DstSecretName {
+	return DstSecretName(shorten(prefix+srcSecretNamespace+DstSecretNameSep+srcSecretName, maxDstSecretNameLength))
+}
+
+// DefaultDstSecretNameTemplate is the template of NewDstSecretName.
+const DefaultDstSecretNameTemplate = "{{.Namespace}}" + DstSecretNameSep + "{{.Name}}"
+
+// NewTemplatedDstSecretName returns the destination name of the source secret given by the Go template
+// over the Namespace, Name, Labels and Annotations of the source secret, prefixed with prefix.
+// An empty template is DefaultDstSecretNameTemplate.
+func NewTemplatedDstSecretName(nameTemplate, prefix string, srcSecret *corev1.Secret) (DstSecretName, error) {
+	if nameTemplate == "" || nameTemplate == DefaultDstSecretNameTemplate {
+		return NewPrefixedDstSecretName(prefix, srcSecret.Namespace, srcSecret.Name), nil
+	}
+	tmpl, err := template.New("destinationNameTemplate").Option("missingkey=zero").Parse(nameTemplate)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, map[string]interface{}{
+		"Namespace":   srcSecret.Namespace,
+		"Name":        srcSecret.Name,
+		"Labels":      srcSecret.Labels,
+		"Annotations": srcSecret.Annotations,
+	})
+	if err != nil {
+		return "", err
+	}
+	return DstSecretName(shorten(prefix+buf.String(), maxDstSecretNameLength)), nil
 }
 
-func (d DstSecretName) Split() (namespace, name string, ok bool) {
-	s := strings.Split(string(d), DstSecretNameSep)
-	if len(s) == 1 {
-		return "", "", false
+// shorten returns s if s is at most max characters, otherwise the head of s followed by a hash of s in max characters.
+func shorten(s string, max int) string {
+	if len(s) <= max {
+		return s
 	}
-	return s[0], s[1], true
+	sum := sha256.Sum256([]byte(s))
+	hash := hex.EncodeToString(sum[:])[:10]
+	// Names and label values must not end with a separator.
+	head := strings.TrimRight(s[:max-len(hash)-1], "-._")
+	return head + "-" + hash
 }
 
 func (d DstSecretName) String() string {
 	return string(d)
 }
 
-func NewDstSecret(dstNamespace string, dstName DstSecretName, srcSecret *corev1.Secret) *corev1.Secret {
+// DstSecretOptions decides how the destination secrets are made from their source secrets.
+type DstSecretOptions struct {
+	// GroupVersionKind is the kind of the synced objects, each of which is handled in the shape of a secret.
+	GroupVersionKind schema.GroupVersionKind
+
+	// NameTemplate is the template of the names, see NewTemplatedDstSecretName.
+	NameTemplate string
+
+	// Labels selects the labels of the source secret to copy.
+	Labels *riggerv1beta1.MetadataRule
+
+	// Annotations selects the annotations of the source secret to copy.
+	Annotations *riggerv1beta1.MetadataRule
+
+	// Keys selects the data keys of the source secret to copy.
+	Keys *riggerv1beta1.KeyRule
+
+	// KeyMappings renames the copied data keys.
+	KeyMappings []riggerv1beta1.KeyMapping
+
+	// Fields selects the fields of the source object to copy, see SecretFromUnstructured.
+	Fields *riggerv1beta1.FieldRule
+
+	// Template renders the merged secret, see NewMergedSecret.
+	Template *riggerv1beta1.SecretTemplate
+}
+
+// NewDstSecretOptions returns the DstSecretOptions of the spec of a plan.
+func NewDstSecretOptions(spec *riggerv1beta1.PlanSpec) DstSecretOptions {
+	return DstSecretOptions{
+		GroupVersionKind: spec.GetGroupVersionKind(),
+		NameTemplate:     spec.DestinationNameTemplate,
+		Labels:           spec.PropagateLabels,
+		Annotations:      spec.PropagateAnnotations,
+		Keys:             spec.Keys,
+		KeyMappings:      spec.KeyMappings,
+		Fields:           spec.Fields,
+		Template:         spec.Template,
+	}
+}
+
+// Name returns the name of the destination secret of srcSecret prefixed with prefix.
+func (o DstSecretOptions) Name(prefix string, srcSecret *corev1.Secret) (DstSecretName, error) {
+	return NewTemplatedDstSecretName(o.NameTemplate, prefix, srcSecret)
+}
+
+func NewDstSecret(plan apitypes.NamespacedName, dstNamespace string, dstName DstSecretName, srcSecret *corev1.Secret, opts DstSecretOptions) *corev1.Secret {
+	labels := propagateMetadata(opts.Labels, srcSecret.Labels)
+	annotations := propagateMetadata(opts.Annotations, srcSecret.Annotations)
+	setPropagatedKeys(annotations, labels)
+	for k, v := range NewDstSecretLabels(plan, srcSecret.Namespace, srcSecret.Name) {
+		labels[k] = v
+	}
+	for k, v := range NewDstSecretAnnotations(plan, srcSecret.Namespace, srcSecret.Name) {
+		annotations[k] = v
+	}
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Namespace: dstNamespace,
-			Name:      dstName.String(),
-			Labels:    NewDstSecretLabels(srcSecret.Namespace, srcSecret.Name),
+			Namespace:   dstNamespace,
+			Name:        dstName.String(),
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Type: srcSecret.Type,
-		Data: srcSecret.Data,
+		Data: transformData(opts.Keys, opts.KeyMappings, selectFields(opts.Fields, srcSecret.Data)),
+	}
+}
+
+// IsDstSecretUpToDate reports whether the existing destination secret has the contents of desired.
+// Only the labels and annotations which rigger owns are compared, so that the ones added by users
+// or other controllers never make rigger rewrite the secret.
+func IsDstSecretUpToDate(existing, desired *corev1.Secret) bool {
+	return existing.Type == desired.Type &&
+		reflect.DeepEqual(existing.Data, desired.Data) &&
+		hasMetadata(existing.Labels, desired.Labels) &&
+		hasMetadata(existing.Annotations, desired.Annotations) &&
+		!hasStaleMetadata(existing, desired)
+}
+
+// hasMetadata reports whether the labels or annotations a have all the entries of b.
+func hasMetadata(a, b map[string]string) bool {
+	for k, v := range b {
+		if got, ok := a[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// hasStaleMetadata reports whether the existing destination secret has the annotations of rigger which desired has not,
+// such as the list of the labels no longer propagated.
+func hasStaleMetadata(existing, desired *corev1.Secret) bool {
+	for k := range existing.Annotations {
+		if !strings.HasPrefix(k, dstSecretAnnotationPrefix) {
+			continue
+		}
+		if _, ok := desired.Annotations[k]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lastAppliedConfigAnnotation is never copied, since it describes the source secret itself.
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// propagateMetadata returns the labels or annotations selected from src by the rule, together with its extra entries.
+// Keys which are not valid after prefixed are dropped.
+func propagateMetadata(rule *riggerv1beta1.MetadataRule, src map[string]string) map[string]string {
+	ret := map[string]string{}
+	if rule == nil {
+		return ret
+	}
+	for k, v := range src {
+		if k == lastAppliedConfigAnnotation {
+			continue
+		}
+		if len(rule.Include) > 0 && !MatchKeyAny(k, rule.Include) {
+			continue
+		}
+		if MatchKeyAny(k, rule.Exclude) {
+			continue
+		}
+		key := rule.Prefix + k
+		if len(validation.IsQualifiedName(key)) > 0 {
+			continue
+		}
+		ret[key] = v
+	}
+	for k, v := range rule.Extra {
+		ret[k] = v
+	}
+	return ret
+}
+
+// transformData returns the data selected from src by the rule and renamed by the mappings.
+// A mapping whose key is not selected is ignored.
+func transformData(rule *riggerv1beta1.KeyRule, mappings []riggerv1beta1.KeyMapping, src map[string][]byte) map[string][]byte {
+	if rule == nil && len(mappings) == 0 {
+		return src
+	}
+	ret := map[string][]byte{}
+	for k, v := range src {
+		if rule != nil && len(rule.Include) > 0 && !MatchKeyAny(k, rule.Include) {
+			continue
+		}
+		if rule != nil && MatchKeyAny(k, rule.Exclude) {
+			continue
+		}
+		ret[k] = v
+	}
+	// Read all the mapped values before deleting any key, so that keys can be swapped.
+	renamed := map[string][]byte{}
+	for _, m := range mappings {
+		if v, ok := ret[m.From]; ok {
+			renamed[m.To] = v
+		}
+	}
+	for _, m := range mappings {
+		delete(ret, m.From)
+	}
+	for k, v := range renamed {
+		ret[k] = v
+	}
+	return ret
+}
+
+// MatchKeyAny reports whether the label, annotation or data key matches any of the patterns,
+// each of which is a key or a glob. Invalid patterns match nothing.
+func MatchKeyAny(key string, patterns []string) bool {
+	for _, p := range patterns {
+		if matched, err := path.Match(p, key); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// dstSecretAnnotationPrefix is the prefix of the annotations which rigger sets on destination secrets.
+const dstSecretAnnotationPrefix = "rigger.k8s.wantedly.com/"
+
+// The annotations listing the keys of the labels and annotations propagated to a destination secret,
+// which tell them from the ones added by others once they are no longer propagated.
+const DstSecretAnnotationPropagatedLabelsKey = "rigger.k8s.wantedly.com/propagated-labels"
+const DstSecretAnnotationPropagatedAnnotationsKey = "rigger.k8s.wantedly.com/propagated-annotations"
+
+// setPropagatedKeys records the keys of the propagated labels and annotations in annotations, unless there are none.
+func setPropagatedKeys(annotations, labels map[string]string) {
+	labelKeys, annotationKeys := joinKeys(labels), joinKeys(annotations)
+	if labelKeys != "" {
+		annotations[DstSecretAnnotationPropagatedLabelsKey] = labelKeys
+	}
+	if annotationKeys != "" {
+		annotations[DstSecretAnnotationPropagatedAnnotationsKey] = annotationKeys
+	}
+}
+
+func joinKeys(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// The annotations holding the identities of the source and the plan of a destination secret.
+// Unlike the labels, they are never shortened.
+const DstSecretAnnotationSrcNamespaceKey = "rigger.k8s.wantedly.com/src-namespace"
+const DstSecretAnnotationSrcNameKey = "rigger.k8s.wantedly.com/src-name"
+const DstSecretAnnotationPlanNamespaceKey = "rigger.k8s.wantedly.com/plan-namespace"
+const DstSecretAnnotationPlanNameKey = "rigger.k8s.wantedly.com/plan-name"
+
+// NewDstSecretAnnotations returns the annotations of the secret which the plan synced from the source secret.
+func NewDstSecretAnnotations(plan apitypes.NamespacedName, srcSecretNamespace, srcSecretName string) map[string]string {
+	return map[string]string{
+		DstSecretAnnotationSrcNamespaceKey:  srcSecretNamespace,
+		DstSecretAnnotationSrcNameKey:       srcSecretName,
+		DstSecretAnnotationPlanNamespaceKey: plan.Namespace,
+		DstSecretAnnotationPlanNameKey:      plan.Name,
+	}
+}
+
+// GetSrcSecret returns the namespace and the name of the source of the destination secret.
+// Secrets synced before the annotations were introduced fall back to the labels.
+func GetSrcSecret(dstSecret *corev1.Secret) (namespace, name string, ok bool) {
+	if name, ok := dstSecret.Annotations[DstSecretAnnotationSrcNameKey]; ok {
+		return dstSecret.Annotations[DstSecretAnnotationSrcNamespaceKey], name, true
 	}
+	name, ok = dstSecret.Labels[DstSecretLabelSrcNameKey]
+	return dstSecret.Labels[DstSecretLabelSrcNamespaceKey], name, ok
+}
+
+// GetDstSecretPlan returns the plan which synced the destination secret.
+// Secrets synced before the annotations were introduced fall back to the labels.
+func GetDstSecretPlan(dstSecret *corev1.Secret) (plan apitypes.NamespacedName, ok bool) {
+	if name, ok := dstSecret.Annotations[DstSecretAnnotationPlanNameKey]; ok {
+		return apitypes.NamespacedName{Namespace: dstSecret.Annotations[DstSecretAnnotationPlanNamespaceKey], Name: name}, true
+	}
+	return DstSecretLabels(dstSecret.Labels).GetPlan()
+}
+
+// IsDstSecretSyncedBy reports whether the plan synced the destination secret.
+func IsDstSecretSyncedBy(dstSecret *corev1.Secret, plan apitypes.NamespacedName) bool {
+	p, ok := GetDstSecretPlan(dstSecret)
+	return ok && p == plan
+}
+
+// IsDstSecretOfOtherPlan reports whether the existing secret, which the plan would sync from the source secret,
+// has been synced by another plan. A secret synced before the plan labels were introduced belongs to the plan
+// syncing the same source, and a secret not synced by rigger belongs to no plan.
+func IsDstSecretOfOtherPlan(existing *corev1.Secret, plan apitypes.NamespacedName, srcSecretNamespace, srcSecretName string) bool {
+	if !IsDstSecret(existing) {
+		return false
+	}
+	if _, ok := GetDstSecretPlan(existing); ok {
+		return !IsDstSecretSyncedBy(existing, plan)
+	}
+	namespace, name, ok := GetSrcSecret(existing)
+	if !ok || namespace != srcSecretNamespace {
+		return true
+	}
+	return name != srcSecretName && name != shorten(srcSecretName, maxLabelValueLength)
 }
 
 const DstSecretLabelCreatedByRiggerKey = "created-by-rigger"
 const DstSecretLabelCreatedByRiggerValue = "true"
 const DstSecretLabelSrcNamespaceKey = "src-namespace"
 const DstSecretLabelSrcNameKey = "src-name"
+const DstSecretLabelPlanNamespaceKey = "plan-namespace"
+const DstSecretLabelPlanNameKey = "plan-name"
+
+// IsDstSecret reports whether the secret has been synced by rigger.
+func IsDstSecret(secret *corev1.Secret) bool {
+	return secret.Labels[DstSecretLabelCreatedByRiggerKey] == DstSecretLabelCreatedByRiggerValue
+}
 
 type DstSecretLabels map[string]string
 
-func NewDstSecretLabels(srcSecretNamespace, srcSecretName string) DstSecretLabels {
+// NewDstSecretLabels returns the labels to select the secrets which the plan synced from the source secret.
+// Names longer than a label value can be are shortened, so the labels must not be parsed.
+func NewDstSecretLabels(plan apitypes.NamespacedName, srcSecretNamespace, srcSecretName string) DstSecretLabels {
+	l := NewPlanDstSecretLabels(plan)
+	l[DstSecretLabelSrcNamespaceKey] = srcSecretNamespace
+	l[DstSecretLabelSrcNameKey] = shorten(srcSecretName, maxLabelValueLength)
+	return l
+}
+
+// NewPlanDstSecretLabels returns the labels shared by every secret synced by the plan.
+func NewPlanDstSecretLabels(plan apitypes.NamespacedName) DstSecretLabels {
 	return DstSecretLabels{
 		DstSecretLabelCreatedByRiggerKey: DstSecretLabelCreatedByRiggerValue,
-		DstSecretLabelSrcNamespaceKey:    srcSecretNamespace,
-		DstSecretLabelSrcNameKey:         srcSecretName,
+		DstSecretLabelPlanNamespaceKey:   plan.Namespace,
+		DstSecretLabelPlanNameKey:        shorten(plan.Name, maxLabelValueLength),
+	}
+}
+
+// GetPlan returns the plan which synced the secret having the labels.
+// It is exact only for the secrets synced before the labels were shortened, use GetDstSecretPlan instead.
+func (d DstSecretLabels) GetPlan() (plan apitypes.NamespacedName, ok bool) {
+	name, ok := d[DstSecretLabelPlanNameKey]
+	if !ok {
+		return apitypes.NamespacedName{}, false
 	}
+	return apitypes.NamespacedName{Namespace: d[DstSecretLabelPlanNamespaceKey], Name: name}, true
 }
 
 func (d DstSecretLabels) GetLabelSelector() string {
-	ret := make([]string, len(d))
+	ret := make([]string, 0, len(d))
 	for k, v := range d {
 		ret = append(ret, k+"="+v)
 	}
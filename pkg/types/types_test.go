@@ -0,0 +1,450 @@
+package types
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestNewPrefixedDstSecretName(t *testing.T) {
+	if got := NewPrefixedDstSecretName("p-", "default", "my.secret"); got != "p-default.my.secret" {
+		t.Errorf("NewPrefixedDstSecretName = %q, want %q", got, "p-default.my.secret")
+	}
+
+	long := strings.Repeat("a", 250)
+	name1 := NewDstSecretName("default", long+"1").String()
+	name2 := NewDstSecretName("default", long+"2").String()
+	if name1 == name2 {
+		t.Errorf("NewDstSecretName returned %q for different secrets", name1)
+	}
+	for _, name := range []string{name1, name2} {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			t.Errorf("NewDstSecretName returned invalid name %q: %v", name, errs)
+		}
+	}
+}
+
+func TestNewTemplatedDstSecretName(t *testing.T) {
+	srcSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "secret",
+		Labels:    map[string]string{"app": "web"},
+	}}
+	cases := []struct {
+		template string
+		want     DstSecretName
+	}{
+		{template: "", want: "p-default.secret"},
+		{template: "{{.Name}}-{{.Namespace}}", want: "p-secret-default"},
+		{template: "{{.Labels.app}}-{{.Name}}", want: "p-web-secret"},
+		{template: "{{.Labels.missing}}{{.Name}}", want: "p-secret"},
+	}
+	for _, c := range cases {
+		got, err := NewTemplatedDstSecretName(c.template, "p-", srcSecret)
+		if err != nil {
+			t.Errorf("NewTemplatedDstSecretName(%q) returned error: %v", c.template, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("NewTemplatedDstSecretName(%q) = %q, want %q", c.template, got, c.want)
+		}
+	}
+}
+
+func TestGetSrcSecret(t *testing.T) {
+	plan := apitypes.NamespacedName{Namespace: "default", Name: "plan"}
+	srcSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: strings.Repeat("a", 100)}}
+	dstSecret := NewDstSecret(plan, "default", NewDstSecretName(srcSecret.Namespace, srcSecret.Name), srcSecret, DstSecretOptions{})
+
+	for k, v := range dstSecret.Labels {
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			t.Errorf("label %s has invalid value %q: %v", k, v, errs)
+		}
+	}
+	namespace, name, ok := GetSrcSecret(dstSecret)
+	if !ok || namespace != srcSecret.Namespace || name != srcSecret.Name {
+		t.Errorf("GetSrcSecret = (%q, %q, %v), want (%q, %q, true)", namespace, name, ok, srcSecret.Namespace, srcSecret.Name)
+	}
+	if got, ok := GetDstSecretPlan(dstSecret); !ok || got != plan {
+		t.Errorf("GetDstSecretPlan = (%v, %v), want (%v, true)", got, ok, plan)
+	}
+}
+
+func TestIsDstSecretOfOtherPlan(t *testing.T) {
+	plan := apitypes.NamespacedName{Namespace: "default", Name: "plan"}
+	other := apitypes.NamespacedName{Namespace: "default", Name: "other"}
+	srcSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "secret"}}
+	dstName := NewDstSecretName(srcSecret.Namespace, srcSecret.Name)
+	// A secret synced before the plan labels were introduced.
+	legacy := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: dstName.String(), Labels: map[string]string{
+		DstSecretLabelCreatedByRiggerKey: DstSecretLabelCreatedByRiggerValue,
+		DstSecretLabelSrcNamespaceKey:    srcSecret.Namespace,
+		DstSecretLabelSrcNameKey:         srcSecret.Name,
+	}}}
+	cases := []struct {
+		name     string
+		existing *corev1.Secret
+		want     bool
+	}{
+		{name: "synced by the plan", existing: NewDstSecret(plan, "default", dstName, srcSecret, DstSecretOptions{}), want: false},
+		{name: "synced by another plan", existing: NewDstSecret(other, "default", dstName, srcSecret, DstSecretOptions{}), want: true},
+		{name: "synced before plan labels", existing: legacy, want: false},
+		{name: "not synced", existing: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: dstName.String()}}, want: false},
+	}
+	for _, c := range cases {
+		if got := IsDstSecretOfOtherPlan(c.existing, plan, srcSecret.Namespace, srcSecret.Name); got != c.want {
+			t.Errorf("%s: IsDstSecretOfOtherPlan = %v, want %v", c.name, got, c.want)
+		}
+	}
+	if !IsDstSecretOfOtherPlan(legacy, plan, "team-b", srcSecret.Name) {
+		t.Errorf("IsDstSecretOfOtherPlan = false for a secret synced before plan labels from another source, want true")
+	}
+}
+
+func TestIsDstSecretUpToDate(t *testing.T) {
+	plan := apitypes.NamespacedName{Namespace: "default", Name: "plan"}
+	srcSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "secret", Labels: map[string]string{"app": "web"}},
+		Data:       map[string][]byte{"token": []byte("a")},
+	}
+	opts := DstSecretOptions{Labels: &riggerv1beta1.MetadataRule{}}
+	desired := NewDstSecret(plan, "default", NewDstSecretName(srcSecret.Namespace, srcSecret.Name), srcSecret, opts)
+	modified := func(modify func(*corev1.Secret)) *corev1.Secret {
+		existing := desired.DeepCopy()
+		modify(existing)
+		return existing
+	}
+	cases := []struct {
+		name     string
+		existing *corev1.Secret
+		want     bool
+	}{
+		{name: "same", existing: desired.DeepCopy(), want: true},
+		{name: "label added by others", existing: modified(func(s *corev1.Secret) { s.Labels["team"] = "a" }), want: true},
+		{name: "annotation added by others", existing: modified(func(s *corev1.Secret) { s.Annotations["note"] = "a" }), want: true},
+		{name: "propagated label changed", existing: modified(func(s *corev1.Secret) { s.Labels["app"] = "api" }), want: false},
+		{name: "rigger annotation removed", existing: modified(func(s *corev1.Secret) { delete(s.Annotations, DstSecretAnnotationPlanNameKey) }), want: false},
+		{name: "data changed", existing: modified(func(s *corev1.Secret) { s.Data["token"] = []byte("b") }), want: false},
+	}
+	for _, c := range cases {
+		if got := IsDstSecretUpToDate(c.existing, desired); got != c.want {
+			t.Errorf("%s: IsDstSecretUpToDate = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// The labels propagated before are rigger's own, unlike the ones added by others.
+	unlabeled := srcSecret.DeepCopy()
+	unlabeled.Labels = nil
+	desired = NewDstSecret(plan, "default", NewDstSecretName(srcSecret.Namespace, srcSecret.Name), unlabeled, opts)
+	cases = []struct {
+		name     string
+		existing *corev1.Secret
+		want     bool
+	}{
+		{name: "label no longer propagated", existing: NewDstSecret(plan, "default", NewDstSecretName(srcSecret.Namespace, srcSecret.Name), srcSecret, opts), want: false},
+		{name: "label added by others", existing: modified(func(s *corev1.Secret) { s.Labels["team"] = "a" }), want: true},
+	}
+	for _, c := range cases {
+		if got := IsDstSecretUpToDate(c.existing, desired); got != c.want {
+			t.Errorf("%s: IsDstSecretUpToDate = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestNewDstSecretPropagation(t *testing.T) {
+	plan := apitypes.NamespacedName{Namespace: "default", Name: "plan"}
+	srcSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "team-a",
+		Name:      "secret",
+		Labels:    map[string]string{"app.kubernetes.io/name": "api", "tier": "backend", DstSecretLabelPlanNameKey: "other"},
+		Annotations: map[string]string{
+			"note":                      "hello",
+			lastAppliedConfigAnnotation: "{}",
+		},
+	}}
+	opts := DstSecretOptions{
+		Labels:      &riggerv1beta1.MetadataRule{Include: []string{"app.kubernetes.io/*", DstSecretLabelPlanNameKey}, Extra: map[string]string{"team": "a"}},
+		Annotations: &riggerv1beta1.MetadataRule{Exclude: []string{"tier"}, Prefix: "src.example.com/"},
+	}
+	dstSecret := NewDstSecret(plan, "default", NewDstSecretName(srcSecret.Namespace, srcSecret.Name), srcSecret, opts)
+
+	for k, want := range map[string]string{"app.kubernetes.io/name": "api", "team": "a", DstSecretLabelPlanNameKey: "plan"} {
+		if got := dstSecret.Labels[k]; got != want {
+			t.Errorf("label %s = %q, want %q", k, got, want)
+		}
+	}
+	if _, ok := dstSecret.Labels["tier"]; ok {
+		t.Errorf("label tier is copied, want not copied")
+	}
+	if got := dstSecret.Annotations["src.example.com/note"]; got != "hello" {
+		t.Errorf("annotation src.example.com/note = %q, want %q", got, "hello")
+	}
+	for k := range dstSecret.Annotations {
+		if strings.HasSuffix(k, lastAppliedConfigAnnotation) {
+			t.Errorf("annotation %s is copied, want not copied", k)
+		}
+	}
+	if !IsDstSecretUpToDate(dstSecret, NewDstSecret(plan, "default", NewDstSecretName(srcSecret.Namespace, srcSecret.Name), srcSecret, opts)) {
+		t.Errorf("IsDstSecretUpToDate = false for the same secret, want true")
+	}
+	if IsDstSecretUpToDate(dstSecret, NewDstSecret(plan, "default", NewDstSecretName(srcSecret.Namespace, srcSecret.Name), srcSecret, DstSecretOptions{})) {
+		t.Errorf("IsDstSecretUpToDate = true for different labels, want false")
+	}
+}
+
+func TestNewDstSecretKeys(t *testing.T) {
+	plan := apitypes.NamespacedName{Namespace: "default", Name: "plan"}
+	srcSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "secret"},
+		Data: map[string][]byte{
+			"user":           []byte("app"),
+			"password":       []byte("pass"),
+			"admin-password": []byte("root"),
+		},
+	}
+	cases := []struct {
+		name string
+		opts DstSecretOptions
+		want map[string]string
+	}{
+		{
+			name: "all keys",
+			want: map[string]string{"user": "app", "password": "pass", "admin-password": "root"},
+		},
+		{
+			name: "include",
+			opts: DstSecretOptions{Keys: &riggerv1beta1.KeyRule{Include: []string{"password"}}},
+			want: map[string]string{"password": "pass"},
+		},
+		{
+			name: "exclude",
+			opts: DstSecretOptions{Keys: &riggerv1beta1.KeyRule{Exclude: []string{"admin-*"}}},
+			want: map[string]string{"user": "app", "password": "pass"},
+		},
+		{
+			name: "mappings",
+			opts: DstSecretOptions{
+				Keys:        &riggerv1beta1.KeyRule{Exclude: []string{"admin-*"}},
+				KeyMappings: []riggerv1beta1.KeyMapping{{From: "password", To: "DB_PASSWORD"}, {From: "admin-password", To: "ROOT_PASSWORD"}},
+			},
+			want: map[string]string{"user": "app", "DB_PASSWORD": "pass"},
+		},
+		{
+			name: "swap",
+			opts: DstSecretOptions{KeyMappings: []riggerv1beta1.KeyMapping{{From: "user", To: "password"}, {From: "password", To: "user"}}},
+			want: map[string]string{"user": "pass", "password": "app", "admin-password": "root"},
+		},
+	}
+	for _, c := range cases {
+		dstSecret := NewDstSecret(plan, "default", NewDstSecretName(srcSecret.Namespace, srcSecret.Name), srcSecret, c.opts)
+		got := map[string]string{}
+		for k, v := range dstSecret.Data {
+			got[k] = string(v)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Data = %v, want %v", c.name, got, c.want)
+		}
+	}
+	if len(srcSecret.Data) != 3 {
+		t.Errorf("NewDstSecret modified the data of the source secret: %v", srcSecret.Data)
+	}
+}
+
+func TestNewMergedSecret(t *testing.T) {
+	plan := apitypes.NamespacedName{Namespace: "default", Name: "plan"}
+	srcSecrets := []*corev1.Secret{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "token"}, Data: map[string][]byte{"token": []byte("b")}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "token"}, Data: map[string][]byte{"token": []byte("a2")}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "api-token"}, Data: map[string][]byte{"token": []byte("a1"), "user": []byte("api")}},
+	}
+	opts := DstSecretOptions{KeyMappings: []riggerv1beta1.KeyMapping{{From: "user", To: "username"}}}
+	merged, skipped, err := NewMergedSecret(plan, "default", "all-tokens", srcSecrets, opts)
+	if err != nil {
+		t.Fatalf("NewMergedSecret returned error: %v", err)
+	}
+	got := map[string]string{}
+	for k, v := range merged.Data {
+		got[k] = string(v)
+	}
+	want := map[string]string{"team-a.token": "a1", "team-a.username": "api", "team-b.token": "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Data = %v, want %v", got, want)
+	}
+	if want := []string{"keys token of secret team-a/token"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+	sources := GetMergedSources(merged)
+	for _, source := range []apitypes.NamespacedName{{Namespace: "team-a", Name: "api-token"}, {Namespace: "team-a", Name: "token"}, {Namespace: "team-b", Name: "token"}} {
+		if !sources.Has(source) {
+			t.Errorf("GetMergedSources has no source %v", source)
+		}
+	}
+	if sources.Has(apitypes.NamespacedName{Namespace: "team-b", Name: "api-token"}) || sources.HasNamespace("team-c") {
+		t.Errorf("GetMergedSources has a source which has not been merged")
+	}
+	if p, ok := GetDstSecretPlan(merged); !ok || p != plan {
+		t.Errorf("GetDstSecretPlan = (%v, %v), want (%v, true)", p, ok, plan)
+	}
+
+	large := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team-c", Name: "large"}, Data: map[string][]byte{"blob": make([]byte, MaxSecretSize)}}
+	if _, _, err := NewMergedSecret(plan, "default", "all-tokens", append(srcSecrets, large), opts); err == nil {
+		t.Errorf("NewMergedSecret returned no error for data larger than MaxSecretSize")
+	}
+	longKey := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team-c", Name: "long-key"}, Data: map[string][]byte{strings.Repeat("k", 200): make([]byte, MaxSecretSize-100)}}
+	if _, _, err := NewMergedSecret(plan, "default", "all-tokens", []*corev1.Secret{longKey}, opts); err == nil {
+		t.Errorf("NewMergedSecret returned no error for keys and data larger than MaxSecretSize")
+	}
+
+	many := make([]*corev1.Secret, 0, 2000)
+	for i := 0; i < cap(many); i++ {
+		many = append(many, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: fmt.Sprintf("team-%04d", i), Name: strings.Repeat("s", 40)}})
+	}
+	merged, _, err = NewMergedSecret(plan, "default", "all-tokens", many, opts)
+	if err != nil {
+		t.Fatalf("NewMergedSecret returned error: %v", err)
+	}
+	if n := len(merged.Annotations[DstSecretAnnotationMergedSourcesKey]); n > maxMergedSourcesSize {
+		t.Errorf("merged-sources annotation has %d bytes, want at most %d", n, maxMergedSourcesSize)
+	}
+	// The sources are held in the bloom filter, which never misses a source and rarely reports another.
+	sources = GetMergedSources(merged)
+	falsePositives := 0
+	for i, srcSecret := range many {
+		if !sources.Has(apitypes.NamespacedName{Namespace: srcSecret.Namespace, Name: srcSecret.Name}) || !sources.HasNamespace(srcSecret.Namespace) {
+			t.Fatalf("GetMergedSources has no source %s/%s", srcSecret.Namespace, srcSecret.Name)
+		}
+		if sources.Has(apitypes.NamespacedName{Namespace: srcSecret.Namespace, Name: "other"}) || sources.HasNamespace(fmt.Sprintf("other-%04d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > len(many)/100 {
+		t.Errorf("GetMergedSources reported %d false positives of %d", falsePositives, 2*len(many))
+	}
+}
+
+func TestNewMergedSecretTemplate(t *testing.T) {
+	plan := apitypes.NamespacedName{Name: "plan"}
+	srcSecrets := []*corev1.Secret{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "registry"}, Data: map[string][]byte{"server": []byte("b.example.com"), "user": []byte("b"), "password": []byte("pb")}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "registry"}, Data: map[string][]byte{"server": []byte("a.example.com"), "user": []byte("a"), "password": []byte("pa")}},
+	}
+	opts := DstSecretOptions{Template: &riggerv1beta1.SecretTemplate{
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string]string{
+			corev1.DockerConfigJsonKey: `{"auths":{ {{- range $i, $s := .Sources}}{{if $i}},{{end}}{{json $s.Data.server}}:{"auth":{{json (b64enc (printf "%s:%s" $s.Data.user $s.Data.password))}}}{{end -}} }}`,
+		},
+	}}
+	merged, _, err := NewMergedSecret(plan, "default", "registries", srcSecrets, opts)
+	if err != nil {
+		t.Fatalf("NewMergedSecret returned error: %v", err)
+	}
+	if merged.Type != corev1.SecretTypeDockerConfigJson {
+		t.Errorf("Type = %q, want %q", merged.Type, corev1.SecretTypeDockerConfigJson)
+	}
+	want := `{"auths":{"a.example.com":{"auth":"YTpwYQ=="},"b.example.com":{"auth":"YjpwYg=="}}}`
+	if got := string(merged.Data[corev1.DockerConfigJsonKey]); got != want {
+		t.Errorf("Data[%s] = %s, want %s", corev1.DockerConfigJsonKey, got, want)
+	}
+
+	opts.Template.Data = map[string]string{"broken": "{{.Missing}}"}
+	_, _, err = NewMergedSecret(plan, "default", "registries", srcSecrets, opts)
+	if _, ok := err.(*TemplateError); !ok {
+		t.Errorf("NewMergedSecret returned %v, want *TemplateError", err)
+	}
+}
+
+func TestConfigMapFromSecret(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "features", Labels: map[string]string{"app": "a"}},
+		Data:       map[string]string{"flags.yaml": "beta: true\n"},
+		BinaryData: map[string][]byte{"logo.png": {0x89, 0x50, 0x4e, 0x47}},
+	}
+	secret := SecretFromConfigMap(cm)
+	if secret.Kind != "ConfigMap" || secret.Type != corev1.SecretTypeOpaque {
+		t.Errorf("Kind, Type = %q, %q, want %q, %q", secret.Kind, secret.Type, "ConfigMap", corev1.SecretTypeOpaque)
+	}
+	if len(secret.Data) != 2 || string(secret.Data["flags.yaml"]) != "beta: true\n" {
+		t.Errorf("Data = %v, want both keys of the configmap", secret.Data)
+	}
+	got := ConfigMapFromSecret(secret)
+	if !reflect.DeepEqual(got.ObjectMeta, cm.ObjectMeta) || !reflect.DeepEqual(got.Data, cm.Data) || !reflect.DeepEqual(got.BinaryData, cm.BinaryData) {
+		t.Errorf("ConfigMapFromSecret = %+v, want %+v", got, cm)
+	}
+}
+
+func TestUnstructuredFromSecret(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "external-secrets.io", Version: "v1beta1", Kind: "ExternalSecret"}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"namespace": "team-a", "name": "db", "labels": map[string]interface{}{"app": "a"}},
+		"spec": map[string]interface{}{
+			"refreshInterval": "1h",
+			"data":            []interface{}{map[string]interface{}{"secretKey": "password", "remoteRef": map[string]interface{}{"key": "db"}}},
+		},
+		"status": map[string]interface{}{"refreshTime": "2019-01-01T00:00:00Z", "syncedResourceVersion": int64(3)},
+	}}
+	u.SetGroupVersionKind(gvk)
+	secret, err := SecretFromUnstructured(u)
+	if err != nil {
+		t.Fatalf("SecretFromUnstructured returned error: %v", err)
+	}
+	if secret.Kind != "ExternalSecret" || secret.Namespace != "team-a" || secret.Labels["app"] != "a" {
+		t.Errorf("Kind, Namespace, Labels = %q, %q, %v, want the ones of the object", secret.Kind, secret.Namespace, secret.Labels)
+	}
+	if len(secret.Data) != 2 || string(secret.Data["status"]) != `{"refreshTime":"2019-01-01T00:00:00Z","syncedResourceVersion":3}` {
+		t.Errorf("Data = %q, want the spec and the status", secret.Data)
+	}
+	got, err := UnstructuredFromSecret(secret, gvk)
+	if err != nil {
+		t.Fatalf("UnstructuredFromSecret returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got.Object["spec"], u.Object["spec"]) || !reflect.DeepEqual(got.Object["status"], u.Object["status"]) {
+		t.Errorf("UnstructuredFromSecret = %v, want %v", got.Object, u.Object)
+	}
+	if got.GroupVersionKind() != gvk || got.GetName() != "db" || got.GetLabels()["app"] != "a" {
+		t.Errorf("GroupVersionKind, Name, Labels = %v, %q, %v, want the ones of the object", got.GroupVersionKind(), got.GetName(), got.GetLabels())
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	data := map[string][]byte{
+		"spec":   []byte(`{"data":[{"secretKey":"password"}],"refreshInterval":"1h","target":{"name":"db"}}`),
+		"status": []byte(`{"refreshTime":"2019-01-01T00:00:00Z"}`),
+	}
+	cases := []struct {
+		name string
+		rule *riggerv1beta1.FieldRule
+		want map[string][]byte
+	}{
+		{name: "nil", want: data},
+		{
+			name: "include",
+			rule: &riggerv1beta1.FieldRule{Include: []string{"spec.target.name", "status", "spec.missing"}},
+			want: map[string][]byte{"spec": []byte(`{"target":{"name":"db"}}`), "status": data["status"]},
+		},
+		{
+			name: "exclude",
+			rule: &riggerv1beta1.FieldRule{Exclude: []string{"spec.refreshInterval", "status"}},
+			want: map[string][]byte{"spec": []byte(`{"data":[{"secretKey":"password"}],"target":{"name":"db"}}`)},
+		},
+		{
+			name: "include and exclude",
+			rule: &riggerv1beta1.FieldRule{Include: []string{"spec"}, Exclude: []string{"spec.data", "spec.target"}},
+			want: map[string][]byte{"spec": []byte(`{"refreshInterval":"1h"}`)},
+		},
+	}
+	for _, c := range cases {
+		if got := selectFields(c.rule, data); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: selectFields = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -2,11 +2,21 @@ package util
 
 import (
 	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
 
 	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -20,6 +30,54 @@ func Contains(s string, ss []string) bool {
 	return false
 }
 
+// MatchNamespace reports whether the namespace name matches the pattern.
+// A pattern beginning with "^" and ending with "$" is a regular expression, otherwise it is a glob
+// such as "kube-*". A pattern without any meta characters matches the exact name.
+func MatchNamespace(pattern, name string) (bool, error) {
+	if isNamespaceRegexp(pattern) {
+		return regexp.MatchString(pattern, name)
+	}
+	return path.Match(pattern, name)
+}
+
+// MatchNamespaceAny reports whether the namespace name matches any of the patterns.
+func MatchNamespaceAny(name string, patterns []string) (bool, error) {
+	for _, p := range patterns {
+		matched, err := MatchNamespace(p, name)
+		if err != nil {
+			return false, err
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ValidateNamespacePattern returns an error if the pattern is neither a valid glob nor a valid regular expression.
+func ValidateNamespacePattern(pattern string) error {
+	if isNamespaceRegexp(pattern) {
+		_, err := regexp.Compile(pattern)
+		return err
+	}
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+func isNamespaceRegexp(pattern string) bool {
+	return len(pattern) > 1 && strings.HasPrefix(pattern, "^") && strings.HasSuffix(pattern, "$")
+}
+
+func Remove(s string, ss []string) []string {
+	ret := []string{}
+	for _, e := range ss {
+		if e != s {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
 func Diff(base, changed []string) (added, deleted []string) {
 	added = []string{}
 	deleted = []string{}
@@ -40,6 +98,106 @@ func Diff(base, changed []string) (added, deleted []string) {
 	return
 }
 
+// NamespaceFilter decides which namespaces secrets are synced from.
+type NamespaceFilter struct {
+	// Namespace limits the matching namespaces to itself if not empty.
+	Namespace string
+
+	IgnoreNamespaces  []string
+	IncludeNamespaces []string
+	NamespaceSelector *metav1.LabelSelector
+}
+
+// NewNamespaceFilter returns the NamespaceFilter of the plan. A Plan only matches its own namespace.
+func NewNamespaceFilter(plan riggerv1beta1.PlanObject) NamespaceFilter {
+	spec := plan.GetSpec()
+	return NamespaceFilter{
+		Namespace:         plan.GetNamespace(),
+		IgnoreNamespaces:  spec.IgnoreNamespaces,
+		IncludeNamespaces: spec.IncludeNamespaces,
+		NamespaceSelector: spec.NamespaceSelector,
+	}
+}
+
+// Matches reports whether secrets are synced from the namespace.
+func (f NamespaceFilter) Matches(ns *corev1.Namespace) (bool, error) {
+	if f.Namespace != "" && f.Namespace != ns.Name {
+		return false, nil
+	}
+	ignored, err := MatchNamespaceAny(ns.Name, f.IgnoreNamespaces)
+	if err != nil {
+		return false, err
+	}
+	if ignored {
+		return false, nil
+	}
+	if len(f.IncludeNamespaces) > 0 {
+		included, err := MatchNamespaceAny(ns.Name, f.IncludeNamespaces)
+		if err != nil {
+			return false, err
+		}
+		if !included {
+			return false, nil
+		}
+	}
+	if f.NamespaceSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(f.NamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// MatchSyncTarget reports whether the secret is a secret of the target.
+func MatchSyncTarget(target *riggerv1beta1.SyncTarget, secret *corev1.Secret) (bool, error) {
+	if target.Name != "" && target.Name != secret.Name {
+		return false, nil
+	}
+	if target.Selector == nil {
+		return target.Name != "", nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(target.Selector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(secret.Labels)), nil
+}
+
+// FindSyncTarget returns the first target of which the secret is a secret, or nil if there is no such target.
+func FindSyncTarget(targets []riggerv1beta1.SyncTarget, secret *corev1.Secret) (*riggerv1beta1.SyncTarget, error) {
+	for i := range targets {
+		matched, err := MatchSyncTarget(&targets[i], secret)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			return &targets[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// MergedSecretName returns the name of the secret into which the plan in Merge mode merges the target secrets.
+func MergedSecretName(plan riggerv1beta1.PlanObject) string {
+	if plan.GetSpec().MergedSecretName == "" {
+		return plan.GetName()
+	}
+	return plan.GetSpec().MergedSecretName
+}
+
+// IsSyncSource reports whether the plan in Collect mode may sync the secret to destNamespace.
+// The secrets synced by rigger are never synced again, so that copies do not chain.
+// Neither are the secrets of destNamespace synced by a ClusterPlan, which would copy them into their own namespace.
+// A Plan syncs only within its own namespace, where both its sources and destNamespace are.
+func IsSyncSource(plan types.NamespacedName, destNamespace string, secret *corev1.Secret) bool {
+	if riggertypes.IsDstSecret(secret) {
+		return false
+	}
+	return plan.Namespace != "" || secret.Namespace != destNamespace
+}
+
 func ReconcilesFetchSecret(r client.Reader, ctx context.Context, key types.NamespacedName) (secret *corev1.Secret, notFound bool, err error) {
 	secret = &corev1.Secret{}
 	if e := r.Get(ctx, key, secret); e != nil {
@@ -52,8 +210,123 @@ func ReconcilesFetchSecret(r client.Reader, ctx context.Context, key types.Names
 	return
 }
 
-func ReconcilesFetchPlan(r client.Reader, ctx context.Context, key types.NamespacedName) (plan *riggerv1beta1.Plan, notFound bool, err error) {
-	plan = &riggerv1beta1.Plan{}
+// NewSyncObject returns an empty object of gvk to watch, which is unstructured unless gvk is Secret or ConfigMap.
+func NewSyncObject(gvk schema.GroupVersionKind) runtime.Object {
+	switch gvk {
+	case riggertypes.SecretGroupVersionKind:
+		return &corev1.Secret{}
+	case riggertypes.ConfigMapGroupVersionKind:
+		return &corev1.ConfigMap{}
+	}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	return u
+}
+
+// ReconcilesFetchObject fetches the object of gvk and key in the shape of a Secret,
+// see riggertypes.SecretFromConfigMap and riggertypes.SecretFromUnstructured.
+func ReconcilesFetchObject(r client.Reader, ctx context.Context, gvk schema.GroupVersionKind, key types.NamespacedName) (secret *corev1.Secret, notFound bool, err error) {
+	var obj runtime.Object
+	switch gvk {
+	case riggertypes.SecretGroupVersionKind:
+		return ReconcilesFetchSecret(r, ctx, key)
+	case riggertypes.ConfigMapGroupVersionKind:
+		obj = &corev1.ConfigMap{}
+	default:
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(gvk)
+		obj = u
+	}
+	if e := r.Get(ctx, key, obj); e != nil {
+		if errors.IsNotFound(e) {
+			return &corev1.Secret{}, true, nil // The received object has been deleted.
+		}
+		return &corev1.Secret{}, false, e
+	}
+	if cm, ok := obj.(*corev1.ConfigMap); ok {
+		return riggertypes.SecretFromConfigMap(cm), false, nil
+	}
+	secret, err = riggertypes.SecretFromUnstructured(obj.(*unstructured.Unstructured))
+	if err != nil {
+		return &corev1.Secret{}, false, err
+	}
+	return secret, false, nil
+}
+
+// ReconcilesListObjects lists the objects of gvk in the shape of Secrets, see ReconcilesFetchObject.
+func ReconcilesListObjects(r client.Reader, ctx context.Context, gvk schema.GroupVersionKind, opts *client.ListOptions) ([]corev1.Secret, error) {
+	switch gvk {
+	case riggertypes.SecretGroupVersionKind:
+		secrets := &corev1.SecretList{}
+		if err := r.List(ctx, opts, secrets); err != nil {
+			return nil, err
+		}
+		return secrets.Items, nil
+	case riggertypes.ConfigMapGroupVersionKind:
+		cms := &corev1.ConfigMapList{}
+		if err := r.List(ctx, opts, cms); err != nil {
+			return nil, err
+		}
+		ret := make([]corev1.Secret, 0, len(cms.Items))
+		for i := range cms.Items {
+			ret = append(ret, *riggertypes.SecretFromConfigMap(&cms.Items[i]))
+		}
+		return ret, nil
+	}
+	ulist := &unstructured.UnstructuredList{}
+	ulist.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := r.List(ctx, opts, ulist); err != nil {
+		return nil, err
+	}
+	ret := make([]corev1.Secret, 0, len(ulist.Items))
+	for i := range ulist.Items {
+		secret, err := riggertypes.SecretFromUnstructured(&ulist.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, *secret)
+	}
+	return ret, nil
+}
+
+// ParseSyncResources parses the resources to sync given by the --sync-resources flag of the manager,
+// which are separated by commas, each in the form of "resource.version.group" such as "externalsecrets.v1beta1.external-secrets.io".
+// The version and the group of the core resources are "v1" and empty, such as "configmaps.v1.".
+func ParseSyncResources(s string) ([]schema.GroupVersionResource, error) {
+	var ret []schema.GroupVersionResource
+	for _, arg := range strings.Split(s, ",") {
+		arg = strings.TrimSpace(arg)
+		if arg == "" {
+			continue
+		}
+		gvr, _ := schema.ParseResourceArg(arg)
+		if gvr == nil {
+			return nil, fmt.Errorf("sync resource %q must be in the form of resource.version.group", arg)
+		}
+		ret = append(ret, *gvr)
+	}
+	return ret, nil
+}
+
+func ReconcilesFetchNamespace(r client.Reader, ctx context.Context, name string) (namespace *corev1.Namespace, notFound bool, err error) {
+	namespace = &corev1.Namespace{}
+	if e := r.Get(ctx, types.NamespacedName{Name: name}, namespace); e != nil {
+		if errors.IsNotFound(e) {
+			notFound = true // The received Namespace has been deleted.
+		} else {
+			err = e
+		}
+	}
+	return
+}
+
+// ReconcilesFetchPlan fetches the Plan of key, or the ClusterPlan of key if key has no namespace.
+func ReconcilesFetchPlan(r client.Reader, ctx context.Context, key types.NamespacedName) (plan riggerv1beta1.PlanObject, notFound bool, err error) {
+	if key.Namespace == "" {
+		plan = &riggerv1beta1.ClusterPlan{}
+	} else {
+		plan = &riggerv1beta1.Plan{}
+	}
 	if e := r.Get(ctx, key, plan); e != nil {
 		if errors.IsNotFound(e) {
 			notFound = true // The received Plan has been deleted.
@@ -64,6 +337,10 @@ func ReconcilesFetchPlan(r client.Reader, ctx context.Context, key types.Namespa
 	return
 }
 
-func ReconcilesUpdatePlan(r client.Writer, ctx context.Context, plan *riggerv1beta1.Plan) error {
+func ReconcilesUpdatePlan(r client.Writer, ctx context.Context, plan riggerv1beta1.PlanObject) error {
 	return r.Update(ctx, plan)
 }
+
+func ReconcilesUpdatePlanStatus(r client.StatusClient, ctx context.Context, plan riggerv1beta1.PlanObject) error {
+	return r.Status().Update(ctx, plan)
+}
@@ -0,0 +1,166 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestMatchNamespace(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "kube-system", name: "kube-system", want: true},
+		{pattern: "kube-system", name: "kube-public", want: false},
+		{pattern: "kube-*", name: "kube-public", want: true},
+		{pattern: "kube-*", name: "default", want: false},
+		{pattern: "^istio-.*$", name: "istio-system", want: true},
+		{pattern: "^istio-.*$", name: "my-istio-system", want: false},
+		{pattern: "^(foo|bar)$", name: "bar", want: true},
+	}
+	for _, c := range cases {
+		got, err := MatchNamespace(c.pattern, c.name)
+		if err != nil {
+			t.Errorf("MatchNamespace(%q, %q) returned error: %v", c.pattern, c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("MatchNamespace(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestValidateNamespacePattern(t *testing.T) {
+	for _, p := range []string{"default", "kube-*", "team-[ab]", "^istio-.*$"} {
+		if err := ValidateNamespacePattern(p); err != nil {
+			t.Errorf("ValidateNamespacePattern(%q) returned error: %v", p, err)
+		}
+	}
+	for _, p := range []string{"team-[ab", "^(istio-$"} {
+		if err := ValidateNamespacePattern(p); err == nil {
+			t.Errorf("ValidateNamespacePattern(%q) returned no error", p)
+		}
+	}
+}
+
+func TestIsSyncSource(t *testing.T) {
+	clusterPlan := types.NamespacedName{Name: "plan"}
+	plan := types.NamespacedName{Namespace: "default", Name: "plan"}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret"}}
+	copied := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "team-a",
+		Name:      "default.secret",
+		Labels:    map[string]string{"created-by-rigger": "true"},
+	}}
+	cases := []struct {
+		name          string
+		plan          types.NamespacedName
+		destNamespace string
+		secret        *corev1.Secret
+		want          bool
+	}{
+		{name: "other namespace", plan: clusterPlan, destNamespace: "team-a", secret: secret, want: true},
+		{name: "dest namespace of ClusterPlan", plan: clusterPlan, destNamespace: "default", secret: secret, want: false},
+		{name: "dest namespace of Plan", plan: plan, destNamespace: "default", secret: secret, want: true},
+		{name: "synced secret", plan: clusterPlan, destNamespace: "default", secret: copied, want: false},
+	}
+	for _, c := range cases {
+		if got := IsSyncSource(c.plan, c.destNamespace, c.secret); got != c.want {
+			t.Errorf("%s: IsSyncSource = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFindSyncTarget(t *testing.T) {
+	spec := &riggerv1beta1.PlanSpec{
+		SyncTargetSecretName: "db-credentials",
+		SyncTargets: []riggerv1beta1.SyncTarget{
+			{Name: "api-token", DestNamePrefix: "api-"},
+			{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"sync": "true"}}, DestNamePrefix: "labeled-"},
+		},
+	}
+	secret := func(name string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: name, Labels: labels}}
+	}
+	cases := []struct {
+		name       string
+		secret     *corev1.Secret
+		wantPrefix string
+		wantFound  bool
+	}{
+		{name: "shorthand name", secret: secret("db-credentials", nil), wantFound: true},
+		{name: "listed name", secret: secret("api-token", nil), wantPrefix: "api-", wantFound: true},
+		{name: "selected by labels", secret: secret("cert", map[string]string{"sync": "true"}), wantPrefix: "labeled-", wantFound: true},
+		{name: "first matching target", secret: secret("api-token", map[string]string{"sync": "true"}), wantPrefix: "api-", wantFound: true},
+		{name: "not a target", secret: secret("cert", nil), wantFound: false},
+	}
+	for _, c := range cases {
+		got, err := FindSyncTarget(spec.GetSyncTargets(), c.secret)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		} else if (got != nil) != c.wantFound {
+			t.Errorf("%s: FindSyncTarget = %v, want found %v", c.name, got, c.wantFound)
+		} else if got != nil && got.DestNamePrefix != c.wantPrefix {
+			t.Errorf("%s: DestNamePrefix = %q, want %q", c.name, got.DestNamePrefix, c.wantPrefix)
+		}
+	}
+}
+
+func TestNamespaceFilterMatches(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter NamespaceFilter
+		ns     string
+		want   bool
+	}{
+		{name: "ClusterPlan", filter: NamespaceFilter{}, ns: "team-a", want: true},
+		{name: "own namespace of Plan", filter: NamespaceFilter{Namespace: "default"}, ns: "default", want: true},
+		{name: "other namespace of Plan", filter: NamespaceFilter{Namespace: "default"}, ns: "team-a", want: false},
+		{name: "ignored own namespace of Plan", filter: NamespaceFilter{Namespace: "default", IgnoreNamespaces: []string{"default"}}, ns: "default", want: false},
+	}
+	for _, c := range cases {
+		got, err := c.filter.Matches(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: c.ns}})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		} else if got != c.want {
+			t.Errorf("%s: Matches = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseSyncResources(t *testing.T) {
+	cases := []struct {
+		s       string
+		want    []schema.GroupVersionResource
+		wantErr bool
+	}{
+		{s: ""},
+		{
+			s: "externalsecrets.v1beta1.external-secrets.io, configmaps.v1.",
+			want: []schema.GroupVersionResource{
+				{Group: "external-secrets.io", Version: "v1beta1", Resource: "externalsecrets"},
+				{Version: "v1", Resource: "configmaps"},
+			},
+		},
+		{s: "externalsecrets", wantErr: true},
+		{s: "deployments.apps", wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := ParseSyncResources(c.s)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseSyncResources(%q) returned error %v, want error %v", c.s, err, c.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseSyncResources(%q) = %v, want %v", c.s, got, c.want)
+		}
+	}
+}
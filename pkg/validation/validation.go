@@ -0,0 +1,400 @@
+// Package validation validates and defaults the specs of Plans and ClusterPlans for the admission webhooks.
+package validation
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	riggertypes "github.com/wantedly/rigger/pkg/types"
+	"github.com/wantedly/rigger/pkg/util"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ValidatePlanSpec returns an error describing why the spec of a Plan or ClusterPlan is invalid.
+func ValidatePlanSpec(spec *riggerv1beta1.PlanSpec) error {
+	for _, p := range spec.IgnoreNamespaces {
+		if err := util.ValidateNamespacePattern(p); err != nil {
+			return fmt.Errorf("invalid pattern %q in ignoreNamespaces: %v", p, err)
+		}
+	}
+	for _, p := range spec.IncludeNamespaces {
+		if err := util.ValidateNamespacePattern(p); err != nil {
+			return fmt.Errorf("invalid pattern %q in includeNamespaces: %v", p, err)
+		}
+	}
+	if spec.GetMode() == riggerv1beta1.PlanModeDistribute {
+		if spec.Source == nil || spec.Source.Namespace == "" || spec.Source.Name == "" {
+			return fmt.Errorf("source.namespace and source.name are required in Distribute mode")
+		}
+	} else {
+		if len(spec.GetSyncTargets()) == 0 {
+			return fmt.Errorf("syncTargetSecretName or syncTargets is required in %s mode", spec.GetMode())
+		}
+		if errs := utilvalidation.IsDNS1123Label(spec.SyncDestNamespace); len(errs) > 0 {
+			return fmt.Errorf("invalid syncDestNamespace %q: %s", spec.SyncDestNamespace, strings.Join(errs, ", "))
+		}
+		if spec.GetMode() == riggerv1beta1.PlanModeMerge {
+			if spec.MergedSecretName != "" {
+				if errs := utilvalidation.IsDNS1123Subdomain(spec.MergedSecretName); len(errs) > 0 {
+					return fmt.Errorf("invalid mergedSecretName %q: %s", spec.MergedSecretName, strings.Join(errs, ", "))
+				}
+			}
+		} else if err := validateDestinationNameTemplate(spec.DestinationNameTemplate); err != nil {
+			return fmt.Errorf("invalid destinationNameTemplate %q: %v", spec.DestinationNameTemplate, err)
+		}
+		// The destination would collect the copies of its own secrets.
+		ignored, err := util.MatchNamespaceAny(spec.SyncDestNamespace, spec.IgnoreNamespaces)
+		if err != nil {
+			return err
+		}
+		if ignored {
+			return fmt.Errorf("syncDestNamespace %q must not be in ignoreNamespaces", spec.SyncDestNamespace)
+		}
+	}
+	for i, t := range spec.SyncTargets {
+		if t.Name == "" && t.Selector == nil {
+			return fmt.Errorf("syncTargets[%d] must have either name or selector", i)
+		}
+		if t.Selector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(t.Selector); err != nil {
+				return fmt.Errorf("invalid selector in syncTargets[%d]: %v", i, err)
+			}
+		}
+	}
+	if err := validateMetadataRule(spec.PropagateLabels, true); err != nil {
+		return fmt.Errorf("invalid propagateLabels: %v", err)
+	}
+	if err := validateMetadataRule(spec.PropagateAnnotations, false); err != nil {
+		return fmt.Errorf("invalid propagateAnnotations: %v", err)
+	}
+	if err := validateKeys(spec.Keys, spec.KeyMappings); err != nil {
+		return err
+	}
+	if spec.Template != nil {
+		if spec.GetMode() != riggerv1beta1.PlanModeMerge {
+			return fmt.Errorf("template is only available in Merge mode")
+		}
+		if err := validateSecretTemplate(spec.Template); err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+		if spec.GetGroupVersionKind() == riggertypes.ConfigMapGroupVersionKind && spec.Template.Type != "" {
+			return fmt.Errorf("template type is not available for ConfigMap")
+		}
+	}
+	return validateSyncKind(spec)
+}
+
+// validateSyncKind validates the kind of the objects to sync and the fields to copy.
+// The kinds other than Secret and ConfigMap are synced field by field, so they are neither merged nor typed.
+func validateSyncKind(spec *riggerv1beta1.PlanSpec) error {
+	if spec.APIVersion != "" {
+		if spec.Kind == "" {
+			return fmt.Errorf("kind is required with apiVersion")
+		}
+		if _, err := schema.ParseGroupVersion(spec.APIVersion); err != nil {
+			return fmt.Errorf("invalid apiVersion: %v", err)
+		}
+	}
+	gvk := spec.GetGroupVersionKind()
+	if gvk == riggertypes.SecretGroupVersionKind || gvk == riggertypes.ConfigMapGroupVersionKind {
+		if spec.Fields != nil {
+			return fmt.Errorf("fields is not available for %s, use keys instead", gvk.Kind)
+		}
+		return nil
+	}
+	if spec.GetMode() == riggerv1beta1.PlanModeMerge {
+		return fmt.Errorf("mode Merge is not available for %s", gvk.Kind)
+	}
+	if spec.Fields == nil {
+		return nil
+	}
+	for _, p := range append(append([]string{}, spec.Fields.Include...), spec.Fields.Exclude...) {
+		if err := validateFieldPath(p); err != nil {
+			return fmt.Errorf("invalid fields: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateFieldPath validates a path of fields joined by dots, which must not point into the identity of an object.
+func validateFieldPath(p string) error {
+	path := strings.Split(p, ".")
+	for _, f := range path {
+		if f == "" {
+			return fmt.Errorf("field path %q has an empty field", p)
+		}
+	}
+	if path[0] == "metadata" || path[0] == "apiVersion" || path[0] == "kind" {
+		return fmt.Errorf("field path %q is never copied", p)
+	}
+	return nil
+}
+
+// DefaultPlanSpec sets the default values to the unset fields of the spec of the plan, which is being created if create.
+// SyncDestNamespace of a Plan defaults to its own namespace, DestinationNameTemplate defaults to
+// DefaultDstSecretNameTemplate, and MergedSecretName defaults to the name of the plan.
+// IgnoreNamespaces of a created plan defaults to DefaultIgnoreNamespaces unless the plan opts out by
+// DefaultIgnoreNamespacesAnnotation, leaving out the namespaces it syncs into. IgnoreNamespaces which the user set,
+// even to an empty list, or which an update leaves out is never defaulted, so that the secrets synced from
+// DefaultIgnoreNamespaces on purpose are not pruned.
+func DefaultPlanSpec(plan riggerv1beta1.PlanObject, create bool) {
+	spec := plan.GetSpec()
+	if spec.Mode == "" {
+		spec.Mode = riggerv1beta1.PlanModeCollect
+	}
+	if spec.Kind == "" && spec.APIVersion == "" {
+		spec.Kind = riggerv1beta1.DefaultSyncKind
+	}
+	if spec.APIVersion == "" {
+		spec.APIVersion = riggerv1beta1.DefaultSyncAPIVersion
+	}
+	if spec.Mode == riggerv1beta1.PlanModeCollect {
+		if spec.SyncDestNamespace == "" {
+			spec.SyncDestNamespace = plan.GetNamespace()
+		}
+		if spec.DestinationNameTemplate == "" {
+			spec.DestinationNameTemplate = riggertypes.DefaultDstSecretNameTemplate
+		}
+	}
+	if spec.Mode == riggerv1beta1.PlanModeMerge {
+		if spec.SyncDestNamespace == "" {
+			spec.SyncDestNamespace = plan.GetNamespace()
+		}
+		if spec.MergedSecretName == "" {
+			spec.MergedSecretName = plan.GetName()
+		}
+	}
+	if !create || spec.IgnoreNamespaces != nil || plan.GetAnnotations()[riggerv1beta1.DefaultIgnoreNamespacesAnnotation] == "false" {
+		return
+	}
+	for _, ns := range riggerv1beta1.DefaultIgnoreNamespaces {
+		if spec.Mode != riggerv1beta1.PlanModeDistribute && ns == spec.SyncDestNamespace {
+			continue
+		}
+		if spec.Mode == riggerv1beta1.PlanModeDistribute && spec.Source != nil && ns == spec.Source.Namespace {
+			continue
+		}
+		spec.IgnoreNamespaces = append(spec.IgnoreNamespaces, ns)
+	}
+}
+
+// validateDestinationNameTemplate returns an error if the template gives invalid names, or the same name
+// to secrets of different namespaces or names.
+func validateDestinationNameTemplate(nameTemplate string) error {
+	if nameTemplate == "" {
+		return nil
+	}
+	secrets := []*corev1.Secret{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "secret"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "token"}},
+	}
+	names := map[riggertypes.DstSecretName]bool{}
+	for _, secret := range secrets {
+		name, err := riggertypes.NewTemplatedDstSecretName(nameTemplate, "", secret)
+		if err != nil {
+			return err
+		}
+		if errs := utilvalidation.IsDNS1123Subdomain(name.String()); len(errs) > 0 {
+			return fmt.Errorf("gives invalid name %q: %s", name, strings.Join(errs, ", "))
+		}
+		if names[name] {
+			return fmt.Errorf("gives the same name %q to different secrets, use both .Namespace and .Name", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// validateMetadataRule returns an error if the rule has malformed patterns, a prefix which makes keys invalid,
+// or invalid extra entries. The values of the extra entries are checked as label values if isLabel is true.
+func validateMetadataRule(rule *riggerv1beta1.MetadataRule, isLabel bool) error {
+	if rule == nil {
+		return nil
+	}
+	for _, p := range append(append([]string{}, rule.Include...), rule.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+	}
+	if rule.Prefix != "" {
+		if errs := utilvalidation.IsQualifiedName(rule.Prefix + "key"); len(errs) > 0 {
+			return fmt.Errorf("invalid prefix %q: %s", rule.Prefix, strings.Join(errs, ", "))
+		}
+	}
+	for k, v := range rule.Extra {
+		if errs := utilvalidation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("invalid extra key %q: %s", k, strings.Join(errs, ", "))
+		}
+		if !isLabel {
+			continue
+		}
+		if errs := utilvalidation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("invalid extra value %q of %q: %s", v, k, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// validateKeys returns an error if the rule has malformed patterns, or the mappings have invalid keys
+// or rename several keys from or to the same key.
+func validateKeys(rule *riggerv1beta1.KeyRule, mappings []riggerv1beta1.KeyMapping) error {
+	if rule != nil {
+		for _, p := range append(append([]string{}, rule.Include...), rule.Exclude...) {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q in keys: %v", p, err)
+			}
+		}
+	}
+	from := map[string]bool{}
+	to := map[string]bool{}
+	for i, m := range mappings {
+		for _, k := range []string{m.From, m.To} {
+			if errs := utilvalidation.IsConfigMapKey(k); len(errs) > 0 {
+				return fmt.Errorf("invalid key %q in keyMappings[%d]: %s", k, i, strings.Join(errs, ", "))
+			}
+		}
+		if from[m.From] {
+			return fmt.Errorf("keyMappings[%d] renames %q more than once", i, m.From)
+		}
+		if to[m.To] {
+			return fmt.Errorf("keyMappings[%d] renames more than one key to %q", i, m.To)
+		}
+		from[m.From] = true
+		to[m.To] = true
+	}
+	return nil
+}
+
+// validateSecretTemplate returns an error if the template has no data, invalid keys or malformed templates.
+func validateSecretTemplate(tmpl *riggerv1beta1.SecretTemplate) error {
+	if len(tmpl.Data) == 0 {
+		return fmt.Errorf("data is required")
+	}
+	for k, text := range tmpl.Data {
+		if errs := utilvalidation.IsConfigMapKey(k); len(errs) > 0 {
+			return fmt.Errorf("invalid key %q: %s", k, strings.Join(errs, ", "))
+		}
+		if _, err := riggertypes.ParseTemplate(k, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PlansConflict reports whether the plans a and b may write objects of the same kind and name to the same namespace,
+// syncing from or to any of namespaces.
+func PlansConflict(a, b riggerv1beta1.PlanObject, namespaces []corev1.Namespace) (bool, error) {
+	specA, specB := a.GetSpec(), b.GetSpec()
+	if specA.GetMode() != specB.GetMode() || specA.GetGroupVersionKind() != specB.GetGroupVersionKind() {
+		return false, nil
+	}
+	var skip []string
+	if specA.GetMode() == riggerv1beta1.PlanModeDistribute {
+		if specA.Source == nil || specB.Source == nil || specA.Source.Name != specB.Source.Name {
+			return false, nil
+		}
+		// The namespace of the source is never written.
+		skip = []string{specA.Source.Namespace, specB.Source.Namespace}
+	} else if specA.GetMode() == riggerv1beta1.PlanModeMerge {
+		// Each plan writes the single secret regardless of the namespaces.
+		return specA.SyncDestNamespace == specB.SyncDestNamespace && util.MergedSecretName(a) == util.MergedSecretName(b), nil
+	} else {
+		if specA.SyncDestNamespace != specB.SyncDestNamespace || !syncTargetsOverlap(specA.GetSyncTargets(), specB.GetSyncTargets()) {
+			return false, nil
+		}
+		// Different templates are assumed to give different names.
+		if destinationNameTemplate(specA) != destinationNameTemplate(specB) {
+			return false, nil
+		}
+	}
+	filterA, filterB := util.NewNamespaceFilter(a), util.NewNamespaceFilter(b)
+	for i := range namespaces {
+		if util.Contains(namespaces[i].Name, skip) {
+			continue
+		}
+		matchedA, err := filterA.Matches(&namespaces[i])
+		if err != nil {
+			return false, err
+		}
+		matchedB, err := filterB.Matches(&namespaces[i])
+		if err != nil {
+			return false, err
+		}
+		if matchedA && matchedB {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func destinationNameTemplate(spec *riggerv1beta1.PlanSpec) string {
+	if spec.DestinationNameTemplate == "" {
+		return riggertypes.DefaultDstSecretNameTemplate
+	}
+	return spec.DestinationNameTemplate
+}
+
+// syncTargetsOverlap reports whether some targets of a and b may select the same secret with the same prefix.
+// Targets selecting only by labels overlap if their selectors are the same.
+func syncTargetsOverlap(a, b []riggerv1beta1.SyncTarget) bool {
+	for _, ta := range a {
+		for _, tb := range b {
+			if ta.DestNamePrefix != tb.DestNamePrefix || ta.Name != tb.Name {
+				continue
+			}
+			if ta.Name != "" || reflect.DeepEqual(ta.Selector, tb.Selector) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FindConflictingPlan returns a Plan or ClusterPlan other than plan which conflicts with plan, or nil if there is none.
+func FindConflictingPlan(r client.Reader, ctx context.Context, plan riggerv1beta1.PlanObject) (riggerv1beta1.PlanObject, error) {
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, &client.ListOptions{}, namespaces); err != nil {
+		return nil, err
+	}
+	clusterPlans := &riggerv1beta1.ClusterPlanList{}
+	if err := r.List(ctx, &client.ListOptions{}, clusterPlans); err != nil {
+		return nil, err
+	}
+	plans := &riggerv1beta1.PlanList{}
+	if err := r.List(ctx, &client.ListOptions{}, plans); err != nil {
+		return nil, err
+	}
+	others := make([]riggerv1beta1.PlanObject, 0, len(clusterPlans.Items)+len(plans.Items))
+	for i := range clusterPlans.Items {
+		others = append(others, &clusterPlans.Items[i])
+	}
+	for i := range plans.Items {
+		others = append(others, &plans.Items[i])
+	}
+	for _, other := range others {
+		if other.GetNamespace() == plan.GetNamespace() && other.GetName() == plan.GetName() {
+			continue
+		}
+		if !other.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		conflict, err := PlansConflict(plan, other, namespaces.Items)
+		if err != nil {
+			return nil, err
+		}
+		if conflict {
+			return other, nil
+		}
+	}
+	return nil, nil
+}
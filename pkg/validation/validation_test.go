@@ -0,0 +1,187 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidatePlanSpec(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    riggerv1beta1.PlanSpec
+		wantErr bool
+	}{
+		{name: "valid", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default"}},
+		{name: "no target", spec: riggerv1beta1.PlanSpec{SyncDestNamespace: "default"}, wantErr: true},
+		{name: "no dest", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret"}, wantErr: true},
+		{name: "invalid dest", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "Default"}, wantErr: true},
+		{name: "ignored dest", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "kube-system", IgnoreNamespaces: []string{"kube-*"}}, wantErr: true},
+		{name: "name template", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", DestinationNameTemplate: "{{.Name}}-{{.Namespace}}"}},
+		{name: "invalid name template", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", DestinationNameTemplate: "{{.Name}}_{{.Namespace}}"}, wantErr: true},
+		{name: "colliding name template", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", DestinationNameTemplate: "copy-{{.Name}}"}, wantErr: true},
+		{name: "propagate labels", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", PropagateLabels: &riggerv1beta1.MetadataRule{Include: []string{"app.kubernetes.io/*"}, Prefix: "src.example.com/", Extra: map[string]string{"team": "a"}}}},
+		{name: "invalid propagate pattern", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", PropagateLabels: &riggerv1beta1.MetadataRule{Exclude: []string{"[app"}}}, wantErr: true},
+		{name: "invalid propagate prefix", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", PropagateAnnotations: &riggerv1beta1.MetadataRule{Prefix: "a/b/"}}, wantErr: true},
+		{name: "invalid extra label value", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", PropagateLabels: &riggerv1beta1.MetadataRule{Extra: map[string]string{"team": "a b"}}}, wantErr: true},
+		{name: "merge", spec: riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeMerge, SyncTargetSecretName: "secret", SyncDestNamespace: "default", MergedSecretName: "all-secrets"}},
+		{name: "merge without target", spec: riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeMerge, SyncDestNamespace: "default"}, wantErr: true},
+		{name: "invalid merged name", spec: riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeMerge, SyncTargetSecretName: "secret", SyncDestNamespace: "default", MergedSecretName: "All_Secrets"}, wantErr: true},
+		{name: "template", spec: riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeMerge, SyncTargetSecretName: "secret", SyncDestNamespace: "default", Template: &riggerv1beta1.SecretTemplate{Data: map[string]string{"pgpass": "{{range .Sources}}{{.Data.password}}\n{{end}}"}}}},
+		{name: "template in Collect mode", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", Template: &riggerv1beta1.SecretTemplate{Data: map[string]string{"pgpass": ""}}}, wantErr: true},
+		{name: "malformed template", spec: riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeMerge, SyncTargetSecretName: "secret", SyncDestNamespace: "default", Template: &riggerv1beta1.SecretTemplate{Data: map[string]string{"pgpass": "{{range .Sources}}"}}}, wantErr: true},
+		{name: "configmap", spec: riggerv1beta1.PlanSpec{Kind: "ConfigMap", SyncTargetSecretName: "features", SyncDestNamespace: "default"}},
+		{name: "typed template of configmap", spec: riggerv1beta1.PlanSpec{Kind: "ConfigMap", Mode: riggerv1beta1.PlanModeMerge, SyncTargetSecretName: "features", SyncDestNamespace: "default", Template: &riggerv1beta1.SecretTemplate{Type: corev1.SecretTypeOpaque, Data: map[string]string{"features": ""}}}, wantErr: true},
+		{name: "custom resource", spec: riggerv1beta1.PlanSpec{APIVersion: "external-secrets.io/v1beta1", Kind: "ExternalSecret", SyncTargetSecretName: "db", SyncDestNamespace: "default", Fields: &riggerv1beta1.FieldRule{Include: []string{"spec"}, Exclude: []string{"spec.refreshInterval"}}}},
+		{name: "apiVersion without kind", spec: riggerv1beta1.PlanSpec{APIVersion: "external-secrets.io/v1beta1", SyncTargetSecretName: "db", SyncDestNamespace: "default"}, wantErr: true},
+		{name: "merged custom resources", spec: riggerv1beta1.PlanSpec{APIVersion: "external-secrets.io/v1beta1", Kind: "ExternalSecret", Mode: riggerv1beta1.PlanModeMerge, SyncTargetSecretName: "db", SyncDestNamespace: "default"}, wantErr: true},
+		{name: "fields of secret", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", Fields: &riggerv1beta1.FieldRule{Include: []string{"data"}}}, wantErr: true},
+		{name: "metadata field", spec: riggerv1beta1.PlanSpec{APIVersion: "external-secrets.io/v1beta1", Kind: "ExternalSecret", SyncTargetSecretName: "db", SyncDestNamespace: "default", Fields: &riggerv1beta1.FieldRule{Include: []string{"metadata.labels"}}}, wantErr: true},
+		{name: "empty field", spec: riggerv1beta1.PlanSpec{APIVersion: "external-secrets.io/v1beta1", Kind: "ExternalSecret", SyncTargetSecretName: "db", SyncDestNamespace: "default", Fields: &riggerv1beta1.FieldRule{Exclude: []string{"spec..data"}}}, wantErr: true},
+		{name: "keys", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", Keys: &riggerv1beta1.KeyRule{Include: []string{"db-*"}}, KeyMappings: []riggerv1beta1.KeyMapping{{From: "db-password", To: "DB_PASSWORD"}}}},
+		{name: "invalid keys pattern", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", Keys: &riggerv1beta1.KeyRule{Include: []string{"[db"}}}, wantErr: true},
+		{name: "invalid mapped key", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", KeyMappings: []riggerv1beta1.KeyMapping{{From: "password", To: "db/password"}}}, wantErr: true},
+		{name: "conflicting key mappings", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", KeyMappings: []riggerv1beta1.KeyMapping{{From: "user", To: "password"}, {From: "pass", To: "password"}}}, wantErr: true},
+		{name: "extra annotation value", spec: riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", PropagateAnnotations: &riggerv1beta1.MetadataRule{Extra: map[string]string{"team": "a b"}}}},
+		{name: "distribute", spec: riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeDistribute, Source: &corev1.SecretReference{Namespace: "default", Name: "secret"}}},
+	}
+	for _, c := range cases {
+		err := ValidatePlanSpec(&c.spec)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: ValidatePlanSpec returned error %v, want error %v", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestPlansConflict(t *testing.T) {
+	namespaces := []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	}
+	newClusterPlan := func(name string, spec riggerv1beta1.PlanSpec) *riggerv1beta1.ClusterPlan {
+		return &riggerv1beta1.ClusterPlan{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+	}
+	cases := []struct {
+		name string
+		a, b riggerv1beta1.PlanSpec
+		want bool
+	}{
+		{
+			name: "same target and dest",
+			a:    riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default"},
+			b:    riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default"},
+			want: true,
+		},
+		{
+			name: "different dest",
+			a:    riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default"},
+			b:    riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "team-a"},
+		},
+		{
+			name: "different prefix",
+			a:    riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default"},
+			b:    riggerv1beta1.PlanSpec{SyncTargets: []riggerv1beta1.SyncTarget{{Name: "secret", DestNamePrefix: "b-"}}, SyncDestNamespace: "default"},
+		},
+		{
+			name: "disjoint namespaces",
+			a:    riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", IncludeNamespaces: []string{"team-a"}},
+			b:    riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default", IncludeNamespaces: []string{"team-b"}},
+		},
+		{
+			name: "different kinds",
+			a:    riggerv1beta1.PlanSpec{SyncTargetSecretName: "secret", SyncDestNamespace: "default"},
+			b:    riggerv1beta1.PlanSpec{Kind: "ConfigMap", SyncTargetSecretName: "secret", SyncDestNamespace: "default"},
+		},
+		{
+			name: "same distributed name",
+			a:    riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeDistribute, Source: &corev1.SecretReference{Namespace: "team-a", Name: "secret"}},
+			b:    riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeDistribute, Source: &corev1.SecretReference{Namespace: "team-b", Name: "secret"}},
+			want: true,
+		},
+		{
+			name: "same merged secret",
+			a:    riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeMerge, SyncTargetSecretName: "token", SyncDestNamespace: "default", MergedSecretName: "b"},
+			b:    riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeMerge, SyncTargetSecretName: "secret", SyncDestNamespace: "default"},
+			want: true,
+		},
+		{
+			name: "different merged secrets",
+			a:    riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeMerge, SyncTargetSecretName: "secret", SyncDestNamespace: "default"},
+			b:    riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeMerge, SyncTargetSecretName: "secret", SyncDestNamespace: "default"},
+		},
+	}
+	for _, c := range cases {
+		got, err := PlansConflict(newClusterPlan("a", c.a), newClusterPlan("b", c.b), namespaces)
+		if err != nil {
+			t.Errorf("%s: PlansConflict returned error: %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: PlansConflict = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDefaultPlanSpec(t *testing.T) {
+	plan := &riggerv1beta1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "plan"}}
+	DefaultPlanSpec(plan, true)
+	if plan.Spec.Mode != riggerv1beta1.PlanModeCollect {
+		t.Errorf("Mode = %q, want %q", plan.Spec.Mode, riggerv1beta1.PlanModeCollect)
+	}
+	if plan.Spec.APIVersion != "v1" || plan.Spec.Kind != "Secret" {
+		t.Errorf("APIVersion, Kind = %q, %q, want %q, %q", plan.Spec.APIVersion, plan.Spec.Kind, "v1", "Secret")
+	}
+	if plan.Spec.SyncDestNamespace != "team-a" {
+		t.Errorf("SyncDestNamespace = %q, want %q", plan.Spec.SyncDestNamespace, "team-a")
+	}
+	if plan.Spec.DestinationNameTemplate != "{{.Namespace}}.{{.Name}}" {
+		t.Errorf("DestinationNameTemplate = %q, want %q", plan.Spec.DestinationNameTemplate, "{{.Namespace}}.{{.Name}}")
+	}
+	if !reflect.DeepEqual(plan.Spec.IgnoreNamespaces, []string{"kube-system", "kube-public"}) {
+		t.Errorf("IgnoreNamespaces = %v, want %v", plan.Spec.IgnoreNamespaces, []string{"kube-system", "kube-public"})
+	}
+
+	plan = &riggerv1beta1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "plan"}}
+	DefaultPlanSpec(plan, true)
+	if !reflect.DeepEqual(plan.Spec.IgnoreNamespaces, []string{"kube-public"}) {
+		t.Errorf("IgnoreNamespaces = %v, want %v", plan.Spec.IgnoreNamespaces, []string{"kube-public"})
+	}
+
+	// IgnoreNamespaces set by the user, or left by an update, is kept as it is.
+	for _, c := range []struct {
+		name             string
+		ignoreNamespaces []string
+		create           bool
+	}{
+		{name: "set on create", ignoreNamespaces: []string{"team-b"}, create: true},
+		{name: "set to empty on create", ignoreNamespaces: []string{}, create: true},
+		{name: "unset on update", create: false},
+	} {
+		plan = &riggerv1beta1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "plan"}, Spec: riggerv1beta1.PlanSpec{IgnoreNamespaces: c.ignoreNamespaces}}
+		DefaultPlanSpec(plan, c.create)
+		if !reflect.DeepEqual(plan.Spec.IgnoreNamespaces, c.ignoreNamespaces) {
+			t.Errorf("%s: IgnoreNamespaces = %v, want %v", c.name, plan.Spec.IgnoreNamespaces, c.ignoreNamespaces)
+		}
+	}
+
+	plan = &riggerv1beta1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "plan"}, Spec: riggerv1beta1.PlanSpec{Mode: riggerv1beta1.PlanModeMerge}}
+	DefaultPlanSpec(plan, false)
+	if plan.Spec.SyncDestNamespace != "team-a" || plan.Spec.MergedSecretName != "plan" || plan.Spec.DestinationNameTemplate != "" {
+		t.Errorf("SyncDestNamespace, MergedSecretName, DestinationNameTemplate = %q, %q, %q, want %q, %q, %q",
+			plan.Spec.SyncDestNamespace, plan.Spec.MergedSecretName, plan.Spec.DestinationNameTemplate, "team-a", "plan", "")
+	}
+
+	clusterPlan := &riggerv1beta1.ClusterPlan{ObjectMeta: metav1.ObjectMeta{
+		Name:        "plan",
+		Annotations: map[string]string{riggerv1beta1.DefaultIgnoreNamespacesAnnotation: "false"},
+	}}
+	DefaultPlanSpec(clusterPlan, true)
+	if len(clusterPlan.Spec.IgnoreNamespaces) != 0 {
+		t.Errorf("IgnoreNamespaces = %v, want empty", clusterPlan.Spec.IgnoreNamespaces)
+	}
+}
@@ -0,0 +1,41 @@
+package mutating
+
+import (
+	"context"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	"github.com/wantedly/rigger/pkg/webhook/default_server/planhandler"
+
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func init() {
+	webhookName := "mutating-create-update-clusterplan"
+	if HandlerMap[webhookName] == nil {
+		HandlerMap[webhookName] = []admission.Handler{}
+	}
+	HandlerMap[webhookName] = append(HandlerMap[webhookName], &ClusterPlanCreateUpdateHandler{})
+}
+
+// ClusterPlanCreateUpdateHandler handles ClusterPlan
+type ClusterPlanCreateUpdateHandler struct {
+	// Decoder decodes objects
+	Decoder types.Decoder
+}
+
+var _ admission.Handler = &ClusterPlanCreateUpdateHandler{}
+
+// Handle handles admission requests.
+func (h *ClusterPlanCreateUpdateHandler) Handle(ctx context.Context, req types.Request) types.Response {
+	return planhandler.HandleMutating(ctx, h.Decoder, req, &riggerv1beta1.ClusterPlan{})
+}
+
+var _ inject.Decoder = &ClusterPlanCreateUpdateHandler{}
+
+// InjectDecoder injects the decoder into the ClusterPlanCreateUpdateHandler
+func (h *ClusterPlanCreateUpdateHandler) InjectDecoder(d types.Decoder) error {
+	h.Decoder = d
+	return nil
+}
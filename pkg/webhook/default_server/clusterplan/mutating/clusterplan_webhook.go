@@ -0,0 +1,20 @@
+package mutating
+
+import (
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/builder"
+)
+
+func init() {
+	builderName := "mutating-create-update-clusterplan"
+	Builders[builderName] = builder.
+		NewWebhookBuilder().
+		Name(builderName+".k8s.wantedly.com").
+		Path("/"+builderName).
+		Mutating().
+		Operations(admissionregistrationv1beta1.Create, admissionregistrationv1beta1.Update).
+		FailurePolicy(admissionregistrationv1beta1.Fail).
+		ForType(&riggerv1beta1.ClusterPlan{})
+}
@@ -0,0 +1,57 @@
+package validating
+
+import (
+	"context"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	"github.com/wantedly/rigger/pkg/webhook/default_server/planhandler"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func init() {
+	webhookName := "validating-create-update-clusterplan"
+	if HandlerMap[webhookName] == nil {
+		HandlerMap[webhookName] = []admission.Handler{}
+	}
+	HandlerMap[webhookName] = append(HandlerMap[webhookName], &ClusterPlanCreateUpdateHandler{})
+}
+
+// ClusterPlanCreateUpdateHandler handles ClusterPlan
+type ClusterPlanCreateUpdateHandler struct {
+	// Client reads the other Plans and ClusterPlans to find conflicts
+	Client client.Client
+
+	// Decoder decodes objects
+	Decoder types.Decoder
+}
+
+func (h *ClusterPlanCreateUpdateHandler) validatingClusterPlanFn(ctx context.Context, obj riggerv1beta1.PlanObject) (bool, string, error) {
+	return planhandler.Validate(ctx, h.Client, obj)
+}
+
+var _ admission.Handler = &ClusterPlanCreateUpdateHandler{}
+
+// Handle handles admission requests.
+func (h *ClusterPlanCreateUpdateHandler) Handle(ctx context.Context, req types.Request) types.Response {
+	return planhandler.HandleValidating(ctx, h.Decoder, req, &riggerv1beta1.ClusterPlan{}, h.validatingClusterPlanFn)
+}
+
+var _ inject.Decoder = &ClusterPlanCreateUpdateHandler{}
+
+// InjectDecoder injects the decoder into the ClusterPlanCreateUpdateHandler
+func (h *ClusterPlanCreateUpdateHandler) InjectDecoder(d types.Decoder) error {
+	h.Decoder = d
+	return nil
+}
+
+var _ inject.Client = &ClusterPlanCreateUpdateHandler{}
+
+// InjectClient injects the client into the ClusterPlanCreateUpdateHandler
+func (h *ClusterPlanCreateUpdateHandler) InjectClient(c client.Client) error {
+	h.Client = c
+	return nil
+}
@@ -0,0 +1,65 @@
+package validating
+
+import (
+	"context"
+	"fmt"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	"github.com/wantedly/rigger/pkg/webhook/default_server/planhandler"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func init() {
+	webhookName := "validating-create-update-plan"
+	if HandlerMap[webhookName] == nil {
+		HandlerMap[webhookName] = []admission.Handler{}
+	}
+	HandlerMap[webhookName] = append(HandlerMap[webhookName], &PlanCreateUpdateHandler{})
+}
+
+// PlanCreateUpdateHandler handles Plan
+type PlanCreateUpdateHandler struct {
+	// Client reads the other Plans and ClusterPlans to find conflicts
+	Client client.Client
+
+	// Decoder decodes objects
+	Decoder types.Decoder
+}
+
+func (h *PlanCreateUpdateHandler) validatingPlanFn(ctx context.Context, obj riggerv1beta1.PlanObject) (bool, string, error) {
+	// A Plan syncs secrets only within its own namespace.
+	if obj.GetSpec().GetMode() == riggerv1beta1.PlanModeDistribute {
+		return false, fmt.Sprintf("%s mode is only available to ClusterPlan", obj.GetSpec().GetMode()), nil
+	}
+	if obj.GetSpec().SyncDestNamespace != obj.GetNamespace() {
+		return false, fmt.Sprintf("syncDestNamespace must be the namespace of the Plan %q, use ClusterPlan to sync to other namespaces", obj.GetNamespace()), nil
+	}
+	return planhandler.Validate(ctx, h.Client, obj)
+}
+
+var _ admission.Handler = &PlanCreateUpdateHandler{}
+
+// Handle handles admission requests.
+func (h *PlanCreateUpdateHandler) Handle(ctx context.Context, req types.Request) types.Response {
+	return planhandler.HandleValidating(ctx, h.Decoder, req, &riggerv1beta1.Plan{}, h.validatingPlanFn)
+}
+
+var _ inject.Decoder = &PlanCreateUpdateHandler{}
+
+// InjectDecoder injects the decoder into the PlanCreateUpdateHandler
+func (h *PlanCreateUpdateHandler) InjectDecoder(d types.Decoder) error {
+	h.Decoder = d
+	return nil
+}
+
+var _ inject.Client = &PlanCreateUpdateHandler{}
+
+// InjectClient injects the client into the PlanCreateUpdateHandler
+func (h *PlanCreateUpdateHandler) InjectClient(c client.Client) error {
+	h.Client = c
+	return nil
+}
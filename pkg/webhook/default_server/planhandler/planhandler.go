@@ -0,0 +1,116 @@
+// Package planhandler holds the admission logic shared by the webhooks of Plan and ClusterPlan,
+// which share PlanSpec. The checks only for Plans are layered on top by the Plan webhooks.
+package planhandler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	riggerv1beta1 "github.com/wantedly/rigger/pkg/apis/rigger/v1beta1"
+	"github.com/wantedly/rigger/pkg/clientset"
+	"github.com/wantedly/rigger/pkg/validation"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// ValidateFunc reports whether the plan is allowed to be admitted, and the reason if not.
+type ValidateFunc func(ctx context.Context, obj riggerv1beta1.PlanObject) (bool, string, error)
+
+// Validate reports whether the Plan or ClusterPlan is allowed to be admitted.
+// It rejects an invalid spec, a kind not configured to sync, and a plan conflicting with another one.
+func Validate(ctx context.Context, c client.Client, obj riggerv1beta1.PlanObject) (bool, string, error) {
+	if err := validation.ValidatePlanSpec(obj.GetSpec()); err != nil {
+		return false, err.Error(), nil
+	}
+	if gvk := obj.GetSpec().GetGroupVersionKind(); !clientset.IsSyncKind(gvk) {
+		return false, fmt.Sprintf("%s is not configured to sync, see --sync-resources of the manager", gvk), nil
+	}
+	conflicting, err := validation.FindConflictingPlan(c, ctx, obj)
+	if err != nil {
+		return false, "", err
+	}
+	if conflicting != nil {
+		return false, fmt.Sprintf("conflicts with %s, which writes secrets of the same names", DescribePlan(conflicting)), nil
+	}
+	return true, "allowed to be admitted", nil
+}
+
+// HandleValidating decodes the request into obj, an empty Plan or ClusterPlan, and validates it by validate.
+// An update which leaves the spec as it is, such as the finalizer of the controller, or an update of a plan
+// being deleted is always allowed, so that a plan admitted before the rules it breaks can still be deleted.
+func HandleValidating(ctx context.Context, decoder types.Decoder, req types.Request, obj riggerv1beta1.PlanObject, validate ValidateFunc) types.Response {
+	err := decoder.Decode(req, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if req.AdmissionRequest.Operation == admissionv1beta1.Update {
+		if !obj.GetDeletionTimestamp().IsZero() {
+			return admission.ValidationResponse(true, "allowed to be deleted")
+		}
+		old, err := decodeOldObject(decoder, req, obj)
+		if err != nil {
+			return admission.ErrorResponse(http.StatusBadRequest, err)
+		}
+		if reflect.DeepEqual(old.GetSpec(), obj.GetSpec()) {
+			return admission.ValidationResponse(true, "allowed to update other than spec")
+		}
+	}
+
+	allowed, reason, err := validate(ctx, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	return admission.ValidationResponse(allowed, reason)
+}
+
+// decodeOldObject decodes the object before the update of the request into an empty object of the type of obj.
+func decodeOldObject(decoder types.Decoder, req types.Request, obj riggerv1beta1.PlanObject) (riggerv1beta1.PlanObject, error) {
+	old := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(riggerv1beta1.PlanObject)
+	oldReq := types.Request{AdmissionRequest: req.AdmissionRequest.DeepCopy()}
+	oldReq.AdmissionRequest.Object = req.AdmissionRequest.OldObject
+	if err := decoder.Decode(oldReq, old); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// HandleMutating decodes the request into obj, an empty Plan or ClusterPlan, and patches it with the defaults.
+// Only a created plan or an updated spec is defaulted, so that the defaults added later never rewrite the spec
+// of an existing plan on an update of its metadata, such as the finalizer of the controller.
+func HandleMutating(ctx context.Context, decoder types.Decoder, req types.Request, obj riggerv1beta1.PlanObject) types.Response {
+	err := decoder.Decode(req, obj)
+	if err != nil {
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	copy := obj.DeepCopyObject().(riggerv1beta1.PlanObject)
+
+	if req.AdmissionRequest.Operation == admissionv1beta1.Update {
+		if !obj.GetDeletionTimestamp().IsZero() {
+			return admission.PatchResponse(obj, copy)
+		}
+		old, err := decodeOldObject(decoder, req, obj)
+		if err != nil {
+			return admission.ErrorResponse(http.StatusBadRequest, err)
+		}
+		if reflect.DeepEqual(old.GetSpec(), obj.GetSpec()) {
+			return admission.PatchResponse(obj, copy)
+		}
+	}
+
+	validation.DefaultPlanSpec(copy, req.AdmissionRequest.Operation == admissionv1beta1.Create)
+	return admission.PatchResponse(obj, copy)
+}
+
+// DescribePlan returns the kind and the name of the plan for humans.
+func DescribePlan(plan riggerv1beta1.PlanObject) string {
+	if plan.GetNamespace() == "" {
+		return fmt.Sprintf("ClusterPlan %q", plan.GetName())
+	}
+	return fmt.Sprintf("Plan %q in namespace %q", plan.GetName(), plan.GetNamespace())
+}